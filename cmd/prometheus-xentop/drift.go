@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os/exec"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/Rudd-O/prometheus-xentop/xlconfig"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// domainConfigs returns the configurations of the domains, from the xl
+// configuration files and then from xl itself, which knows better.
+func (g *XenCollector) domainConfigs(ctx context.Context) map[string]xlconfig.Config {
+	configs := make(map[string]xlconfig.Config)
+	if g.opts.XLConfigDir != "" {
+		c, err := xlconfig.ReadConfigDir(g.opts.XLConfigDir)
+		if err != nil {
+			log.Printf("Error reading xl configuration files: %s", err)
+		}
+		for name, config := range c {
+			configs[name] = config
+		}
+	}
+	if g.opts.XLPath != "" {
+		out, err := exec.CommandContext(ctx, g.opts.XLPath, "list", "-l").Output()
+		if err != nil {
+			log.Printf("Error running %s list -l: %s", g.opts.XLPath, err)
+			return configs
+		}
+		c, err := xlconfig.ParseXLList(bytes.NewReader(out))
+		if err != nil {
+			log.Printf("Error reading %s list -l: %s", g.opts.XLPath, err)
+		}
+		for name, config := range c {
+			configs[name] = config
+		}
+	}
+	return configs
+}
+
+// collectDrift exports the difference between the actual and configured
+// values of the domains in the snapshot that have a configuration.
+func (g *XenCollector) collectDrift(ctx context.Context, ch chan<- prometheus.Metric, snapshot xenstat.Snapshot) {
+	configs := g.domainConfigs(ctx)
+
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+	for _, domain := range snapshot.Domains {
+		config, ok := configs[domain.Name]
+		if !ok {
+			continue
+		}
+		dom := g.domLabel(domain)
+		for _, d := range xlconfig.Compare(config, domain) {
+			// Device counts are not known unless collected.
+			if (d.Field == "vbds" && !g.opts.VBDs) || (d.Field == "nics" && !g.opts.Networks) {
+				continue
+			}
+			ch <- f(m["domain_config_drift"].Desc, m["domain_config_drift"].Type, d.Delta(), dom, d.Field)
+		}
+	}
+}
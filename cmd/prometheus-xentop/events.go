@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventKey identifies a series of xen_domain_events_total.
+type eventKey struct {
+	dom   string
+	event string
+}
+
+// eventCounter counts the domain lifecycle events seen between scrapes.
+// Events that happen and revert between two scrapes are not seen.  The
+// counts of a domain are forgotten once it has not been seen for
+// stateExpiry.
+type eventCounter struct {
+	prev     *xenstat.Snapshot
+	counts   map[eventKey]uint64
+	lastSeen map[string]time.Time
+	mu       sync.Mutex
+}
+
+func newEventCounter() *eventCounter {
+	return &eventCounter{counts: make(map[eventKey]uint64), lastSeen: make(map[string]time.Time)}
+}
+
+// collectEvents counts the events since the previous snapshot and
+// exports every count seen so far.
+func (g *XenCollector) collectEvents(ch chan<- prometheus.Metric, snapshot xenstat.Snapshot) {
+	e := g.events
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if e.prev != nil {
+		for _, ev := range xenstat.DiffSnapshots(*e.prev, snapshot) {
+			dom := g.domLabel(ev.Domain)
+			e.counts[eventKey{dom, ev.Type.String()}]++
+			e.lastSeen[dom] = now
+		}
+	}
+	e.prev = &snapshot
+	for _, d := range snapshot.Domains {
+		e.lastSeen[g.domLabel(d)] = now
+	}
+	for dom, t := range e.lastSeen {
+		if now.Sub(t) > stateExpiry {
+			delete(e.lastSeen, dom)
+		}
+	}
+	for k := range e.counts {
+		if _, ok := e.lastSeen[k.dom]; !ok {
+			delete(e.counts, k)
+		}
+	}
+
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+	for k, n := range e.counts {
+		ch <- f(m["domain_events_total"].Desc, m["domain_events_total"].Type, float64(n), k.dom, k.event)
+	}
+}
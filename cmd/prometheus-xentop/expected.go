@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expectedDomain is an entry of the expected domains file.
+type expectedDomain struct {
+	// Pattern matches the names of the domains, as in path.Match.
+	Pattern string
+	// States, if not zero, requires the domain to be in one of these states.
+	States xenstat.DomainState
+}
+
+// matches returns true if the domain fulfils the entry.
+func (e expectedDomain) matches(d xenstat.DomainInfo) bool {
+	if ok, _ := path.Match(e.Pattern, d.Name); !ok {
+		return false
+	}
+	return e.States == 0 || d.State&e.States != 0
+}
+
+// parseExpectedDomains parses the expected domains file.  Each line holds
+// a domain name or glob pattern, optionally followed by a comma-separated
+// list of states the domain must be in one of, such as running,blocked.
+// Empty lines and lines starting with # are ignored.
+func parseExpectedDomains(r io.Reader) ([]expectedDomain, error) {
+	var entries []expectedDomain
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: too many fields", n)
+		}
+		e := expectedDomain{Pattern: fields[0]}
+		if _, err := path.Match(e.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if len(fields) == 2 {
+			if err := e.States.UnmarshalText([]byte(fields[1])); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// expectedDomains is the list of domains that should be running.  The
+// file is read again whenever it changes.  If it cannot be read, the
+// previous list is kept.
+type expectedDomains struct {
+	path    string
+	entries []expectedDomain
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+func newExpectedDomains(path string) *expectedDomains {
+	return &expectedDomains{path: path}
+}
+
+// load reads the file if it changed.  The caller must hold the lock.
+func (e *expectedDomains) load() error {
+	f, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(e.modTime) {
+		return nil
+	}
+	entries, err := parseExpectedDomains(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", e.path, err)
+	}
+	e.entries = entries
+	e.modTime = fi.ModTime()
+	return nil
+}
+
+// collectExpected exports, for every entry of the expected domains file,
+// whether a domain of the snapshot fulfils it.  The dom label is the
+// pattern of the entry.
+func (g *XenCollector) collectExpected(ch chan<- prometheus.Metric, snapshot xenstat.Snapshot) {
+	e := g.expected
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.load(); err != nil {
+		log.Printf("Error reading expected domains: %s", err)
+	}
+
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+	seen := make(map[string]bool)
+	for _, entry := range e.entries {
+		// Only the first of several entries with the same pattern counts.
+		if seen[entry.Pattern] {
+			continue
+		}
+		seen[entry.Pattern] = true
+		present := 0.0
+		for _, d := range snapshot.Domains {
+			if entry.matches(d) {
+				present = 1.0
+				break
+			}
+		}
+		ch <- f(m["domain_expected"].Desc, m["domain_expected"].Type, 1, entry.Pattern)
+		ch <- f(m["domain_present"].Desc, m["domain_present"].Type, present, entry.Pattern)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+)
+
+func TestParseExpectedDomains(t *testing.T) {
+	entries, err := parseExpectedDomains(strings.NewReader(`
+# Infrastructure
+sys-net running,blocked
+sys-firewall
+  web*   running
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []expectedDomain{
+		{"sys-net", xenstat.Running | xenstat.Blocked},
+		{"sys-firewall", 0},
+		{"web*", xenstat.Running},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestParseExpectedDomainsErrors(t *testing.T) {
+	for _, src := range []string{
+		"web1 running blocked",
+		"web[ running",
+		"web1 sleeping",
+	} {
+		if _, err := parseExpectedDomains(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestExpectedDomainMatches(t *testing.T) {
+	// Xen reports idle guests as blocked rather than running.
+	idle := xenstat.DomainInfo{Name: "web1", State: xenstat.Blocked}
+	busy := xenstat.DomainInfo{Name: "web1", State: xenstat.Running}
+	paused := xenstat.DomainInfo{Name: "web1", State: xenstat.Paused}
+	tests := []struct {
+		entry  string
+		domain xenstat.DomainInfo
+		want   bool
+	}{
+		{"web1", paused, true},
+		{"web*", idle, true},
+		{"db*", idle, false},
+		{"web1 running,blocked", idle, true},
+		{"web1 running,blocked", busy, true},
+		{"web1 running,blocked", paused, false},
+		{"web1 running", idle, false},
+		{"web1 running", busy, true},
+	}
+	for _, tt := range tests {
+		entries, err := parseExpectedDomains(strings.NewReader(tt.entry))
+		if err != nil {
+			t.Fatalf("%q: %s", tt.entry, err)
+		}
+		if got := entries[0].matches(tt.domain); got != tt.want {
+			t.Errorf("%q matches %s domain: got %v, want %v", tt.entry, tt.domain.State, got, tt.want)
+		}
+	}
+}
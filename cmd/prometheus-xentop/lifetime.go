@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lifetimeCounters maps the counters accumulated across domain restarts
+// to the metrics they are exported as.
+var lifetimeCounters = map[string]string{
+	"cpu_seconds":          "domain_lifetime_cpu_seconds_total",
+	"vbd_read_requests":    "domain_lifetime_vbd_read_requests_total",
+	"vbd_write_requests":   "domain_lifetime_vbd_write_requests_total",
+	"vbd_read_bytes":       "domain_lifetime_vbd_read_bytes_total",
+	"vbd_written_bytes":    "domain_lifetime_vbd_written_bytes_total",
+	"net_transmit_bytes":   "domain_lifetime_net_transmit_bytes_total",
+	"net_receive_bytes":    "domain_lifetime_net_receive_bytes_total",
+	"net_transmit_packets": "domain_lifetime_net_transmit_packets_total",
+	"net_receive_packets":  "domain_lifetime_net_receive_packets_total",
+}
+
+// lifetimeCounter is the last raw value of a counter of a domain, and its
+// total across every domain the VM has run as.
+type lifetimeCounter struct {
+	Last  float64
+	Total float64
+}
+
+// vmLifetime is the persistent state of a VM.
+type vmLifetime struct {
+	// DomainID is the domain the VM was last seen running as.
+	DomainID uint32
+	// LastSeen is when the VM was last seen running.
+	LastSeen time.Time
+	// Counters is keyed by counter name, followed by a slash and the
+	// device number for per-device counters.
+	Counters map[string]*lifetimeCounter
+}
+
+// lifetimeState is what is saved in the state file.
+type lifetimeState struct {
+	VMs map[string]*vmLifetime
+}
+
+// lifetimeAccumulator carries the counters of VMs forward across domain
+// restarts, which reset the counters Xen reports, and across restarts of
+// the exporter if it has a state file.
+type lifetimeAccumulator struct {
+	path  string
+	state lifetimeState
+	mu    sync.Mutex
+}
+
+// newLifetimeAccumulator returns an accumulator saving its state to path,
+// loading any state previously saved there.  If path is empty, the state
+// is only kept in memory.
+func newLifetimeAccumulator(path string) *lifetimeAccumulator {
+	a := &lifetimeAccumulator{path: path, state: lifetimeState{VMs: make(map[string]*vmLifetime)}}
+	if path == "" {
+		return a
+	}
+	var state lifetimeState
+	if err := loadState(path, &state); err != nil {
+		log.Printf("Error reading lifetime state %s, starting afresh: %s", path, err)
+		return a
+	}
+	if state.VMs != nil {
+		a.state = state
+	}
+	return a
+}
+
+// save writes the state to the state file, atomically.  The caller must
+// hold the lock.
+func (a *lifetimeAccumulator) save() error {
+	if a.path == "" {
+		return nil
+	}
+	return saveState(a.path, a.state)
+}
+
+// domainCounters returns the raw counters of a domain, keyed the same way
+// as vmLifetime.Counters.
+func domainCounters(d xenstat.DomainInfo) map[string]float64 {
+	c := map[string]float64{"cpu_seconds": d.CPUSeconds}
+	for _, v := range d.VBDs {
+		c[fmt.Sprintf("vbd_read_requests/%d", v.Dev)] = float64(v.ReadRequests)
+		c[fmt.Sprintf("vbd_write_requests/%d", v.Dev)] = float64(v.WriteRequests)
+		c[fmt.Sprintf("vbd_read_bytes/%d", v.Dev)] = float64(v.BytesRead)
+		c[fmt.Sprintf("vbd_written_bytes/%d", v.Dev)] = float64(v.BytesWritten)
+	}
+	for _, v := range d.NICs {
+		c[fmt.Sprintf("net_transmit_bytes/%d", v.ID)] = float64(v.BytesTransmitted)
+		c[fmt.Sprintf("net_receive_bytes/%d", v.ID)] = float64(v.BytesReceived)
+		c[fmt.Sprintf("net_transmit_packets/%d", v.ID)] = float64(v.PacketsTransmitted)
+		c[fmt.Sprintf("net_receive_packets/%d", v.ID)] = float64(v.PacketsReceived)
+	}
+	return c
+}
+
+// update accumulates the counters of a domain the VM is running as.  A
+// counter that went backwards is assumed to have restarted from zero, as
+// are all counters once the VM runs as another domain than last time,
+// including those of devices the new domain does not have yet.
+func (v *vmLifetime) update(d xenstat.DomainInfo, now time.Time) {
+	if v.DomainID != d.DomainID {
+		for _, c := range v.Counters {
+			c.Last = 0
+		}
+	}
+	for name, cur := range domainCounters(d) {
+		c, ok := v.Counters[name]
+		if !ok {
+			c = &lifetimeCounter{}
+			v.Counters[name] = c
+		}
+		if cur < c.Last {
+			c.Total += cur
+		} else {
+			c.Total += cur - c.Last
+		}
+		c.Last = cur
+	}
+	v.DomainID = d.DomainID
+	v.LastSeen = now
+}
+
+// vmKey returns the stable identity of the VM a domain runs, which is
+// its UUID when labelling by UUID, and its name otherwise.
+func (g *XenCollector) vmKey(domain xenstat.DomainInfo) string {
+	if g.opts.LabelBy == LabelByUUID && domain.UUID != "" {
+		return domain.UUID
+	}
+	return domain.Name
+}
+
+// collectLifetime accumulates the counters of the domains in the snapshot
+// and exports the lifetime totals of the VMs they run, labelled by VM key
+// rather than domLabel, since domain IDs change on every restart.
+func (g *XenCollector) collectLifetime(ch chan<- prometheus.Metric, snapshot xenstat.Snapshot) {
+	a := g.lifetime
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+	now := time.Now()
+	for _, domain := range snapshot.Domains {
+		key := g.vmKey(domain)
+		v, ok := a.state.VMs[key]
+		if !ok {
+			v = &vmLifetime{DomainID: domain.DomainID, Counters: make(map[string]*lifetimeCounter)}
+			a.state.VMs[key] = v
+		}
+		v.update(domain, now)
+
+		totals := make(map[string]float64)
+		for name, c := range v.Counters {
+			totals[strings.SplitN(name, "/", 2)[0]] += c.Total
+		}
+		for counter, total := range totals {
+			name := lifetimeCounters[counter]
+			ch <- f(m[name].Desc, m[name].Type, total, key)
+		}
+	}
+
+	for key, v := range a.state.VMs {
+		if now.Sub(v.LastSeen) > stateExpiry {
+			delete(a.state.VMs, key)
+		}
+	}
+	if err := a.save(); err != nil {
+		log.Printf("Error saving lifetime state: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDomainCounters(t *testing.T) {
+	d := xenstat.DomainInfo{
+		CPUSeconds: 12,
+		VBDs:       []xenstat.VBDInfo{{Dev: 51712, ReadRequests: 1, WriteRequests: 2, BytesRead: 3, BytesWritten: 4}},
+		NICs:       []xenstat.NICInfo{{ID: 1, BytesTransmitted: 5, BytesReceived: 6, PacketsTransmitted: 7, PacketsReceived: 8}},
+	}
+	want := map[string]float64{
+		"cpu_seconds":              12,
+		"vbd_read_requests/51712":  1,
+		"vbd_write_requests/51712": 2,
+		"vbd_read_bytes/51712":     3,
+		"vbd_written_bytes/51712":  4,
+		"net_transmit_bytes/1":     5,
+		"net_receive_bytes/1":      6,
+		"net_transmit_packets/1":   7,
+		"net_receive_packets/1":    8,
+	}
+	if got := domainCounters(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func lifetimeDomain(domid uint32, cpu float64, reads map[uint32]uint64) xenstat.DomainInfo {
+	d := xenstat.DomainInfo{DomainID: domid, Name: "web1", CPUSeconds: cpu}
+	for dev, n := range reads {
+		d.VBDs = append(d.VBDs, xenstat.VBDInfo{Dev: dev, ReadRequests: n})
+	}
+	return d
+}
+
+func TestLifetimeUpdate(t *testing.T) {
+	v := &vmLifetime{DomainID: 1, Counters: make(map[string]*lifetimeCounter)}
+	now := time.Now()
+	steps := []struct {
+		domain xenstat.DomainInfo
+		cpu    float64
+		xvda   float64
+		xvdb   float64
+	}{
+		{lifetimeDomain(1, 10, map[uint32]uint64{51712: 100, 51728: 50}), 10, 100, 50},
+		{lifetimeDomain(1, 15, map[uint32]uint64{51712: 120, 51728: 70}), 15, 120, 70},
+		// Restarted as domain 2, without xvdb for now.
+		{lifetimeDomain(2, 3, map[uint32]uint64{51712: 10}), 18, 130, 70},
+		// xvdb is hot-plugged with more reads than it had before the
+		// restart, all of which are new.
+		{lifetimeDomain(2, 4, map[uint32]uint64{51712: 15, 51728: 80}), 19, 135, 150},
+	}
+	for i, s := range steps {
+		v.update(s.domain, now)
+		got := []float64{v.Counters["cpu_seconds"].Total, v.Counters["vbd_read_requests/51712"].Total, v.Counters["vbd_read_requests/51728"].Total}
+		if want := []float64{s.cpu, s.xvda, s.xvdb}; !reflect.DeepEqual(got, want) {
+			t.Errorf("step %d: got totals %v, want %v", i, got, want)
+		}
+	}
+}
+
+// lifetimeCPU scrapes c and returns the lifetime CPU seconds of web1.
+func lifetimeCPU(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "xen_domain_lifetime_cpu_seconds_total" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			if m.Label[0].GetValue() == "web1" {
+				return m.Counter.GetValue()
+			}
+		}
+	}
+	t.Fatal("no lifetime CPU seconds for web1")
+	return 0
+}
+
+func TestLifetimeStateReload(t *testing.T) {
+	opts := CollectorOptions{
+		Lifetime:          true,
+		LifetimeStateFile: filepath.Join(t.TempDir(), "lifetime.json"),
+	}
+	src := xenstat.NewFakeSource(xenstat.FakeStep{Snapshot: xenstat.Snapshot{
+		Domains: []xenstat.DomainInfo{lifetimeDomain(1, 100, nil)},
+	}})
+	if got := lifetimeCPU(t, NewXenCollector(src, opts)); got != 100 {
+		t.Errorf("first run: got %v, want 100", got)
+	}
+
+	// The exporter restarts, and so does the VM.
+	src = xenstat.NewFakeSource(xenstat.FakeStep{Snapshot: xenstat.Snapshot{
+		Domains: []xenstat.DomainInfo{lifetimeDomain(2, 5, nil)},
+	}})
+	if got := lifetimeCPU(t, NewXenCollector(src, opts)); got != 105 {
+		t.Errorf("after reload: got %v, want 105", got)
+	}
+}
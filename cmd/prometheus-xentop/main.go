@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/Rudd-O/prometheus-xentop/sysfs"
 	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/Rudd-O/prometheus-xentop/xenstore"
+	"github.com/Rudd-O/prometheus-xentop/xlconfig"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -24,6 +30,66 @@ func knownMetrics() map[string]knownMetric {
 		Description string
 		Labels      []string
 	}{
+		"up": {
+			"gauge", "Whether the last poll of the Xen host succeeded", nil,
+		},
+		"exporter_reconnects_total": {
+			"counter", "Count of times the exporter has reconnected to the Xen host", nil,
+		},
+		"node_memory_total_bytes": {
+			"gauge", "Total physical memory of the Xen host", nil,
+		},
+		"node_memory_free_bytes": {
+			"gauge", "Physical memory of the Xen host not allocated to any domain", nil,
+		},
+		"node_memory_freeable_bytes": {
+			"gauge", "Physical memory of the Xen host that the hypervisor could free", nil,
+		},
+		"node_cpus": {
+			"gauge", "Count of physical CPUs of the Xen host", nil,
+		},
+		"node_cpu_hz": {
+			"gauge", "Clock frequency of the physical CPUs of the Xen host", nil,
+		},
+		"node_info": {
+			"gauge", "Information about the Xen host, always 1", []string{"version"},
+		},
+		"pcpu_busy_seconds_total": {
+			"counter", "Total number of seconds this physical CPU has spent executing domains", []string{"cpu"},
+		},
+		"pcpu_online": {
+			"gauge", "Whether this physical CPU is online", []string{"cpu"},
+		},
+		"domain_info": {
+			"gauge", "Identity of this domain, always 1", []string{"dom", "name", "domid", "uuid"},
+		},
+		"domain_expected": {
+			"gauge", "Whether domains matching this pattern are expected to run, always 1", []string{"dom"},
+		},
+		"domain_present": {
+			"gauge", "Whether a domain matching this pattern runs, in the expected state if any", []string{"dom"},
+		},
+		"domain_config_drift": {
+			"gauge", "Difference between the actual and configured value of this setting of this domain", []string{"dom", "field"},
+		},
+		"domain_state": {
+			"gauge", "Whether this domain is in this state (1) or not (0)", []string{"dom", "state"},
+		},
+		"domain_events_total": {
+			"counter", "Count of lifecycle events of this domain seen between scrapes", []string{"dom", "event"},
+		},
+		"domain_shutdown_reason": {
+			"gauge", "Why this domain is shut down, always 1", []string{"dom", "reason"},
+		},
+		"domain_restarts_total": {
+			"counter", "Count of times this VM came back as a new domain", []string{"dom"},
+		},
+		"domain_crashes_total": {
+			"counter", "Count of times a domain of this VM was seen entering the crashed state", []string{"dom"},
+		},
+		"domain_start_time_seconds": {
+			"gauge", "Time the exporter first saw the current domain of this VM, in seconds since the epoch", []string{"dom"},
+		},
 		"cpu_seconds_total": {
 			"counter", "Total number of seconds spent across all CPUs executing in this domain", []string{"dom"},
 		},
@@ -36,23 +102,107 @@ func knownMetrics() map[string]knownMetric {
 		"memory_maximum_bytes": {
 			"gauge", "Maximum memory this domain is allowed to allocate, assuming availability", []string{"dom"},
 		},
+		"memory_target_bytes": {
+			"gauge", "Balloon target of this domain", []string{"dom"},
+		},
+		"memory_static_max_bytes": {
+			"gauge", "Most memory this domain can be ballooned up to", []string{"dom"},
+		},
+		"memory_videoram_bytes": {
+			"gauge", "Memory set aside for the emulated video card of this domain", []string{"dom"},
+		},
+		"memory_guest_used_bytes": {
+			"gauge", "Memory this domain reports as used", []string{"dom"},
+		},
+		"memory_guest_free_bytes": {
+			"gauge", "Memory this domain reports as free", []string{"dom"},
+		},
+		"memory_guest_cached_bytes": {
+			"gauge", "Memory this domain reports as used for buffers and caches", []string{"dom"},
+		},
 		"vbd_count": {
 			"gauge", "Count of virtual block devices assigned to this domain", []string{"dom"},
 		},
 		"vbd_out_of_requests_errors_total": {
-			"counter", "Count of out-of-request situations this domain has encountered", []string{"dom", "major", "minor"},
+			"counter", "Count of out-of-request situations this domain has encountered", []string{"dom", "device", "major", "minor"},
 		},
 		"vbd_read_requests_total": {
-			"counter", "Count of read requests this domain has issued", []string{"dom", "major", "minor"},
+			"counter", "Count of read requests this domain has issued", []string{"dom", "device", "major", "minor"},
 		},
 		"vbd_write_requests_total": {
-			"counter", "Count of write requests this domain has issued", []string{"dom", "major", "minor"},
+			"counter", "Count of write requests this domain has issued", []string{"dom", "device", "major", "minor"},
 		},
 		"vbd_read_bytes_total": {
-			"counter", "Total bytes this domain has read from virtual block devices", []string{"dom", "major", "minor"},
+			"counter", "Total bytes this domain has read from virtual block devices", []string{"dom", "device", "major", "minor"},
 		},
 		"vbd_written_bytes_total": {
-			"counter", "Total bytes this domain has written to from virtual block devices", []string{"dom", "major", "minor"},
+			"counter", "Total bytes this domain has written to from virtual block devices", []string{"dom", "device", "major", "minor"},
+		},
+		"net_transmit_fifo_errors_total": {
+			"counter", "Count of FIFO errors on packets transmitted by this domain, as seen by netback", []string{"dom", "nic"},
+		},
+		"net_receive_fifo_errors_total": {
+			"counter", "Count of FIFO errors on packets received by this domain, as seen by netback", []string{"dom", "nic"},
+		},
+		"net_transmit_frame_errors_total": {
+			"counter", "Count of frame errors on packets transmitted by this domain, as seen by netback", []string{"dom", "nic"},
+		},
+		"net_transmit_multicast_packets_total": {
+			"counter", "Count of multicast packets transmitted by this domain, as seen by netback", []string{"dom", "nic"},
+		},
+		"net_collisions_total": {
+			"counter", "Count of collisions on virtual network devices of this domain, as seen by netback", []string{"dom", "nic"},
+		},
+		"net_receive_carrier_errors_total": {
+			"counter", "Count of carrier errors on packets received by this domain, as seen by netback", []string{"dom", "nic"},
+		},
+		"net_carrier": {
+			"gauge", "Whether the netback interface of this virtual network device has a carrier", []string{"dom", "nic"},
+		},
+		"net_operstate": {
+			"gauge", "Operational state of the netback interface of this virtual network device, always 1", []string{"dom", "nic", "operstate"},
+		},
+		"vcpu_seconds_total": {
+			"counter", "Total number of seconds spent by this virtual CPU executing in this domain", []string{"dom", "vcpu"},
+		},
+		"vcpu_online": {
+			"gauge", "Whether this virtual CPU of this domain is online", []string{"dom", "vcpu"},
+		},
+		"vbd_flush_requests_total": {
+			"counter", "Count of flush requests this domain has issued, as seen by blkback", []string{"dom", "device", "major", "minor"},
+		},
+		"vbd_discard_requests_total": {
+			"counter", "Count of discard requests this domain has issued, as seen by blkback", []string{"dom", "device", "major", "minor"},
+		},
+		"vbd_backend_info": {
+			"gauge", "Backend of this virtual block device as seen by blkback, always 1", []string{"dom", "device", "major", "minor", "mode", "physical_device"},
+		},
+		"domain_lifetime_cpu_seconds_total": {
+			"counter", "Total number of seconds spent across all CPUs executing in this VM, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_vbd_read_requests_total": {
+			"counter", "Count of read requests this VM has issued, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_vbd_write_requests_total": {
+			"counter", "Count of write requests this VM has issued, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_vbd_read_bytes_total": {
+			"counter", "Total bytes this VM has read from virtual block devices, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_vbd_written_bytes_total": {
+			"counter", "Total bytes this VM has written to virtual block devices, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_net_transmit_bytes_total": {
+			"counter", "Total bytes this VM has transmitted through virtual network devices, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_net_receive_bytes_total": {
+			"counter", "Total bytes this VM has received through virtual network devices, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_net_transmit_packets_total": {
+			"counter", "Total packets this VM has transmitted through virtual network devices, across domain restarts", []string{"dom"},
+		},
+		"domain_lifetime_net_receive_packets_total": {
+			"counter", "Total packets this VM has received through virtual network devices, across domain restarts", []string{"dom"},
 		},
 		"nic_count": {
 			"gauge", "Count of virtual network devices assigned to this domain", []string{"dom"},
@@ -63,6 +213,24 @@ func knownMetrics() map[string]knownMetric {
 		"net_receive_bytes_total": {
 			"counter", "Total bytes this domain has received through virtual network devices", []string{"dom", "nic"},
 		},
+		"net_transmit_packets_total": {
+			"counter", "Total packets this domain has transmitted through virtual network devices", []string{"dom", "nic"},
+		},
+		"net_receive_packets_total": {
+			"counter", "Total packets this domain has received through virtual network devices", []string{"dom", "nic"},
+		},
+		"net_transmit_errors_total": {
+			"counter", "Count of transmit errors on virtual network devices of this domain", []string{"dom", "nic"},
+		},
+		"net_receive_errors_total": {
+			"counter", "Count of receive errors on virtual network devices of this domain", []string{"dom", "nic"},
+		},
+		"net_transmit_drops_total": {
+			"counter", "Count of outgoing packets dropped on virtual network devices of this domain", []string{"dom", "nic"},
+		},
+		"net_receive_drops_total": {
+			"counter", "Count of incoming packets dropped on virtual network devices of this domain", []string{"dom", "nic"},
+		},
 	} {
 		fullName := prometheus.BuildFQName("xen", "", metricName)
 		var typ prometheus.ValueType
@@ -82,66 +250,455 @@ func knownMetrics() map[string]knownMetric {
 	return m
 }
 
+// LabelBy selects which domain attribute is used as the dom label.
+type LabelBy string
+
+const (
+	LabelByName  LabelBy = "name"
+	LabelByDomID LabelBy = "domid"
+	LabelByUUID  LabelBy = "uuid"
+)
+
+// Set implements flag.Value.
+func (l *LabelBy) Set(v string) error {
+	switch LabelBy(v) {
+	case LabelByName, LabelByDomID, LabelByUUID:
+		*l = LabelBy(v)
+		return nil
+	}
+	return fmt.Errorf("must be one of %s, %s or %s", LabelByName, LabelByDomID, LabelByUUID)
+}
+
+func (l *LabelBy) String() string {
+	return string(*l)
+}
+
+// CollectorOptions selects which optional metric families are exported.
+type CollectorOptions struct {
+	// LabelBy selects the domain attribute that identifies series.
+	LabelBy LabelBy
+	// VCPUs enables the per-VCPU metrics, which can be numerous on large hosts.
+	VCPUs bool
+	// Networks enables the virtual network device metrics.
+	Networks bool
+	// VBDs enables the virtual block device metrics.
+	VBDs bool
+	// PollTimeout bounds how long a scrape waits for the Xen host.
+	// Zero means no timeout.
+	PollTimeout time.Duration
+	// MemoryDetails enables the memory metrics read from xenstore.
+	MemoryDetails bool
+	// Netback enables the netback statistics read from sysfs.
+	Netback bool
+	// Blkback enables the blkback statistics read from sysfs.
+	Blkback bool
+	// SysfsRoot is where sysfs is mounted.
+	SysfsRoot string
+	// XenstorePath, if not empty, is the xenstored socket or xenbus
+	// device used to fill in domain details the backend lacks.
+	XenstorePath string
+	// Lifetime enables the counters accumulated across domain restarts.
+	Lifetime bool
+	// LifetimeStateFile, if not empty, is where the lifetime counters are
+	// saved so that they survive restarts of the exporter.
+	LifetimeStateFile string
+	// RestartStateFile, if not empty, is where the restart history of VMs
+	// is saved so that it survives restarts of the exporter.
+	RestartStateFile string
+	// ExpectedDomainsFile, if not empty, lists the domains that should
+	// be running.
+	ExpectedDomainsFile string
+	// XLConfigDir, if not empty, is where the xl configuration files
+	// of domains are read from to detect configuration drift.
+	XLConfigDir string
+	// XLPath, if not empty, is the xl program run to get the
+	// configuration of domains to detect configuration drift.
+	XLPath string
+}
+
+// Flags returns the collection flags needed for the enabled metric
+// families, so no data is fetched that would not be exported.
+func (o CollectorOptions) Flags() xenstat.Flags {
+	f := xenstat.CollectXenVersion
+	if o.VCPUs {
+		f |= xenstat.CollectVCPUs
+	}
+	if o.Networks {
+		f |= xenstat.CollectNetworks
+	}
+	if o.VBDs {
+		f |= xenstat.CollectVBDs
+	}
+	if o.MemoryDetails {
+		f |= xenstat.CollectMemoryDetails
+	}
+	return f
+}
+
+func vcpusEnabled(o CollectorOptions) bool            { return o.VCPUs }
+func networksEnabled(o CollectorOptions) bool         { return o.Networks }
+func vbdsEnabled(o CollectorOptions) bool             { return o.VBDs }
+func netbackEnabled(o CollectorOptions) bool          { return o.Netback }
+func blkbackEnabled(o CollectorOptions) bool          { return o.VBDs && o.Blkback }
+func lifetimeEnabled(o CollectorOptions) bool         { return o.Lifetime }
+func lifetimeVBDsEnabled(o CollectorOptions) bool     { return o.Lifetime && o.VBDs }
+func lifetimeNetworksEnabled(o CollectorOptions) bool { return o.Lifetime && o.Networks }
+func memoryEnabled(o CollectorOptions) bool           { return o.MemoryDetails }
+func expectedEnabled(o CollectorOptions) bool         { return o.ExpectedDomainsFile != "" }
+func driftEnabled(o CollectorOptions) bool            { return o.XLConfigDir != "" || o.XLPath != "" }
+
+// optionalMetrics maps the metric families that can be disabled to
+// the function that tells whether they are enabled.
+var optionalMetrics = map[string]func(CollectorOptions) bool{
+	"vcpu_seconds_total":                         vcpusEnabled,
+	"vcpu_online":                                vcpusEnabled,
+	"nic_count":                                  networksEnabled,
+	"net_transmit_bytes_total":                   networksEnabled,
+	"net_receive_bytes_total":                    networksEnabled,
+	"net_transmit_packets_total":                 networksEnabled,
+	"net_receive_packets_total":                  networksEnabled,
+	"net_transmit_errors_total":                  networksEnabled,
+	"net_receive_errors_total":                   networksEnabled,
+	"net_transmit_drops_total":                   networksEnabled,
+	"net_receive_drops_total":                    networksEnabled,
+	"net_transmit_fifo_errors_total":             netbackEnabled,
+	"net_receive_fifo_errors_total":              netbackEnabled,
+	"net_transmit_frame_errors_total":            netbackEnabled,
+	"net_transmit_multicast_packets_total":       netbackEnabled,
+	"net_collisions_total":                       netbackEnabled,
+	"net_receive_carrier_errors_total":           netbackEnabled,
+	"net_carrier":                                netbackEnabled,
+	"net_operstate":                              netbackEnabled,
+	"vbd_count":                                  vbdsEnabled,
+	"domain_config_drift":                        driftEnabled,
+	"domain_expected":                            expectedEnabled,
+	"domain_present":                             expectedEnabled,
+	"memory_target_bytes":                        memoryEnabled,
+	"memory_static_max_bytes":                    memoryEnabled,
+	"memory_videoram_bytes":                      memoryEnabled,
+	"memory_guest_used_bytes":                    memoryEnabled,
+	"memory_guest_free_bytes":                    memoryEnabled,
+	"memory_guest_cached_bytes":                  memoryEnabled,
+	"domain_lifetime_cpu_seconds_total":          lifetimeEnabled,
+	"domain_lifetime_vbd_read_requests_total":    lifetimeVBDsEnabled,
+	"domain_lifetime_vbd_write_requests_total":   lifetimeVBDsEnabled,
+	"domain_lifetime_vbd_read_bytes_total":       lifetimeVBDsEnabled,
+	"domain_lifetime_vbd_written_bytes_total":    lifetimeVBDsEnabled,
+	"domain_lifetime_net_transmit_bytes_total":   lifetimeNetworksEnabled,
+	"domain_lifetime_net_receive_bytes_total":    lifetimeNetworksEnabled,
+	"domain_lifetime_net_transmit_packets_total": lifetimeNetworksEnabled,
+	"domain_lifetime_net_receive_packets_total":  lifetimeNetworksEnabled,
+	"vbd_out_of_requests_errors_total":           vbdsEnabled,
+	"vbd_read_requests_total":                    vbdsEnabled,
+	"vbd_write_requests_total":                   vbdsEnabled,
+	"vbd_read_bytes_total":                       vbdsEnabled,
+	"vbd_written_bytes_total":                    vbdsEnabled,
+	"vbd_flush_requests_total":                   blkbackEnabled,
+	"vbd_discard_requests_total":                 blkbackEnabled,
+	"vbd_backend_info":                           blkbackEnabled,
+}
+
+// reconnecter is implemented by sources that reconnect by themselves,
+// such as xenstat.Client.
+type reconnecter interface {
+	Reconnects() uint64
+}
+
 type XenCollector struct {
-	x       *xenstat.XenStats
-	metrics map[string]knownMetric
+	x        xenstat.Source
+	opts     CollectorOptions
+	xs       *xenstoreEnricher
+	events   *eventCounter
+	lifetime *lifetimeAccumulator
+	restarts *restartTracker
+	expected *expectedDomains
+	metrics  map[string]knownMetric
 }
 
-func NewXenCollector() *XenCollector {
-	return &XenCollector{nil, knownMetrics()}
+// NewXenCollector returns a collector polling x.  If x does not reconnect
+// by itself (like xenstat.Client does), the collector stops reporting
+// domains after the first disconnection.
+func NewXenCollector(x xenstat.Source, opts CollectorOptions) *XenCollector {
+	g := &XenCollector{
+		x:        x,
+		opts:     opts,
+		events:   newEventCounter(),
+		restarts: newRestartTracker(opts.RestartStateFile),
+		metrics:  knownMetrics(),
+	}
+	if opts.XenstorePath != "" {
+		g.xs = newXenstoreEnricher(opts.XenstorePath, opts.MemoryDetails)
+	}
+	if opts.ExpectedDomainsFile != "" {
+		g.expected = newExpectedDomains(opts.ExpectedDomainsFile)
+	}
+	if opts.Lifetime {
+		g.lifetime = newLifetimeAccumulator(opts.LifetimeStateFile)
+	}
+	return g
+}
+
+// domLabel returns the value of the dom label for the domain.  Domains
+// without a known UUID, or whose UUID is taken by another domain, fall
+// back to their name when labelling by UUID.
+func (g *XenCollector) domLabel(domain xenstat.DomainInfo) string {
+	switch g.opts.LabelBy {
+	case LabelByDomID:
+		return fmt.Sprintf("%d", domain.DomainID)
+	case LabelByUUID:
+		if domain.UUID != "" {
+			return domain.UUID
+		}
+	}
+	return domain.Name
+}
+
+// forgetDuplicateUUIDs clears the UUID of every domain that shares it
+// with an earlier domain, so that those domains are labelled by name
+// instead.  Both ends of a local migration, such as web1--incoming and
+// web1--migratedaway, run the same VM and so have the same UUID.
+func forgetDuplicateUUIDs(domains []xenstat.DomainInfo) {
+	seen := make(map[string]bool)
+	for i, d := range domains {
+		if d.UUID == "" {
+			continue
+		}
+		if seen[d.UUID] {
+			domains[i].UUID = ""
+		}
+		seen[d.UUID] = true
+	}
 }
 
 func (g *XenCollector) Describe(ch chan<- *prometheus.Desc) {
-	for _, metric := range g.metrics {
+	for name, metric := range g.metrics {
+		if enabled, ok := optionalMetrics[name]; ok && !enabled(g.opts) {
+			continue
+		}
 		ch <- metric.Desc
 	}
 }
 
 func (g *XenCollector) Collect(ch chan<- prometheus.Metric) {
-	var err error
-	if g.x == nil {
-		if g.x, err = xenstat.NewXenStats(); err != nil {
-			log.Printf("Error collecting metrics: %s", err)
-			return
-		}
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+
+	if r, ok := g.x.(reconnecter); ok {
+		ch <- f(m["exporter_reconnects_total"].Desc, m["exporter_reconnects_total"].Type, float64(r.Reconnects()))
 	}
 
-	domaindata, err := g.x.Poll()
+	ctx := context.Background()
+	if g.opts.PollTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.PollTimeout)
+		defer cancel()
+	}
+	snapshot, err := xenstat.PollContext(ctx, g.x)
 	if err != nil {
-		g.x.Close()
-		g.x = nil
+		ch <- f(m["up"].Desc, m["up"].Type, 0)
 		log.Printf("Error collecting metrics: %s", err)
 		return
 	}
+	ch <- f(m["up"].Desc, m["up"].Type, 1)
+	if g.xs != nil {
+		g.xs.Enrich(snapshot.Domains)
+	}
+	if g.opts.LabelBy == LabelByUUID {
+		forgetDuplicateUUIDs(snapshot.Domains)
+	}
+	if g.opts.Netback {
+		g.collectNetback(ch, snapshot)
+	}
+	if g.opts.VBDs && g.opts.Blkback {
+		if err := sysfs.FillVBDs(g.opts.SysfsRoot, snapshot.Domains); err != nil {
+			log.Printf("Error reading blkback statistics: %s", err)
+		}
+	}
+	g.collectEvents(ch, snapshot)
+	g.collectRestarts(ch, snapshot)
+	if g.expected != nil {
+		g.collectExpected(ch, snapshot)
+	}
+	if driftEnabled(g.opts) {
+		g.collectDrift(ctx, ch, snapshot)
+	}
+	if g.lifetime != nil {
+		g.collectLifetime(ch, snapshot)
+	}
 
-	f := prometheus.MustNewConstMetric
-	m := g.metrics
-	for _, domain := range domaindata {
-		ch <- f(m["cpu_seconds_total"].Desc, m["cpu_seconds_total"].Type, float64(domain.CPUSeconds), domain.Name)
-		ch <- f(m["cpu_count"].Desc, m["cpu_count"].Type, float64(domain.NumVCPUs), domain.Name)
-		ch <- f(m["memory_used_bytes"].Desc, m["memory_used_bytes"].Type, float64(domain.MemoryBytes), domain.Name)
-		ch <- f(m["memory_maximum_bytes"].Desc, m["memory_maximum_bytes"].Type, float64(domain.MaxmemBytes), domain.Name)
-		ch <- f(m["vbd_count"].Desc, m["vbd_count"].Type, float64(domain.NumVBDs), domain.Name)
-		ch <- f(m["nic_count"].Desc, m["nic_count"].Type, float64(domain.NumNICs), domain.Name)
-		for _, v := range domain.VBDs {
-			ch <- f(m["vbd_out_of_requests_errors_total"].Desc, m["vbd_out_of_requests_errors_total"].Type, float64(v.OutOfRequests), domain.Name, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
-			ch <- f(m["vbd_read_requests_total"].Desc, m["vbd_read_requests_total"].Type, float64(v.ReadRequests), domain.Name, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
-			ch <- f(m["vbd_write_requests_total"].Desc, m["vbd_write_requests_total"].Type, float64(v.WriteRequests), domain.Name, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
-			ch <- f(m["vbd_read_bytes_total"].Desc, m["vbd_read_bytes_total"].Type, float64(v.BytesRead), domain.Name, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
-			ch <- f(m["vbd_written_bytes_total"].Desc, m["vbd_written_bytes_total"].Type, float64(v.BytesWritten), domain.Name, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
-		}
-		for n, v := range domain.NICs {
-			ch <- f(m["net_transmit_bytes_total"].Desc, m["net_transmit_bytes_total"].Type, float64(v.BytesTransmitted), domain.Name, fmt.Sprintf("%d", n))
-			ch <- f(m["net_receive_bytes_total"].Desc, m["net_receive_bytes_total"].Type, float64(v.BytesReceived), domain.Name, fmt.Sprintf("%d", n))
+	// Backends such as xentop do not report the host at all.
+	node := snapshot.Node
+	if node.NumCPUs > 0 {
+		ch <- f(m["node_memory_total_bytes"].Desc, m["node_memory_total_bytes"].Type, float64(node.TotalMemoryBytes))
+		ch <- f(m["node_memory_free_bytes"].Desc, m["node_memory_free_bytes"].Type, float64(node.FreeMemoryBytes))
+		ch <- f(m["node_memory_freeable_bytes"].Desc, m["node_memory_freeable_bytes"].Type, float64(node.FreeableMemoryBytes))
+		ch <- f(m["node_cpus"].Desc, m["node_cpus"].Type, float64(node.NumCPUs))
+		ch <- f(m["node_cpu_hz"].Desc, m["node_cpu_hz"].Type, float64(node.CPUHz))
+		ch <- f(m["node_info"].Desc, m["node_info"].Type, 1, node.XenVersion)
+	}
+	for n, c := range snapshot.PCPUs {
+		online := 0.0
+		if c.Online {
+			online = 1.0
+		}
+		ch <- f(m["pcpu_busy_seconds_total"].Desc, m["pcpu_busy_seconds_total"].Type, c.BusySeconds, fmt.Sprintf("%d", n))
+		ch <- f(m["pcpu_online"].Desc, m["pcpu_online"].Type, online, fmt.Sprintf("%d", n))
+	}
+	for _, domain := range snapshot.Domains {
+		dom := g.domLabel(domain)
+		ch <- f(m["domain_info"].Desc, m["domain_info"].Type, 1, dom, domain.Name, fmt.Sprintf("%d", domain.DomainID), domain.UUID)
+		for _, state := range xenstat.DomainStates {
+			in := 0.0
+			if domain.State.Has(state) {
+				in = 1.0
+			}
+			ch <- f(m["domain_state"].Desc, m["domain_state"].Type, in, dom, state.String())
+		}
+		if domain.ShutdownReason != "" {
+			ch <- f(m["domain_shutdown_reason"].Desc, m["domain_shutdown_reason"].Type, 1, dom, domain.ShutdownReason)
+		}
+		ch <- f(m["cpu_seconds_total"].Desc, m["cpu_seconds_total"].Type, float64(domain.CPUSeconds), dom)
+		ch <- f(m["cpu_count"].Desc, m["cpu_count"].Type, float64(domain.NumVCPUs), dom)
+		ch <- f(m["memory_used_bytes"].Desc, m["memory_used_bytes"].Type, float64(domain.MemoryBytes), dom)
+		ch <- f(m["memory_maximum_bytes"].Desc, m["memory_maximum_bytes"].Type, float64(domain.MaxmemBytes), dom)
+		if g.opts.MemoryDetails {
+			// Only the details found in xenstore are exported.
+			for name, value := range map[string]uint64{
+				"memory_target_bytes":       domain.Memory.TargetBytes,
+				"memory_static_max_bytes":   domain.Memory.StaticMaxBytes,
+				"memory_videoram_bytes":     domain.Memory.VideoRAMBytes,
+				"memory_guest_used_bytes":   domain.Memory.GuestUsedBytes,
+				"memory_guest_free_bytes":   domain.Memory.GuestFreeBytes,
+				"memory_guest_cached_bytes": domain.Memory.GuestCachedBytes,
+			} {
+				if value != 0 {
+					ch <- f(m[name].Desc, m[name].Type, float64(value), dom)
+				}
+			}
+		}
+		if g.opts.VBDs {
+			ch <- f(m["vbd_count"].Desc, m["vbd_count"].Type, float64(domain.NumVBDs), dom)
+			for _, v := range domain.VBDs {
+				ch <- f(m["vbd_out_of_requests_errors_total"].Desc, m["vbd_out_of_requests_errors_total"].Type, float64(v.OutOfRequests), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+				ch <- f(m["vbd_read_requests_total"].Desc, m["vbd_read_requests_total"].Type, float64(v.ReadRequests), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+				ch <- f(m["vbd_write_requests_total"].Desc, m["vbd_write_requests_total"].Type, float64(v.WriteRequests), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+				ch <- f(m["vbd_read_bytes_total"].Desc, m["vbd_read_bytes_total"].Type, float64(v.BytesRead), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+				ch <- f(m["vbd_written_bytes_total"].Desc, m["vbd_written_bytes_total"].Type, float64(v.BytesWritten), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+				if g.opts.Blkback && v.Mode != "" {
+					ch <- f(m["vbd_flush_requests_total"].Desc, m["vbd_flush_requests_total"].Type, float64(v.FlushRequests), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+					ch <- f(m["vbd_discard_requests_total"].Desc, m["vbd_discard_requests_total"].Type, float64(v.DiscardRequests), dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor))
+					ch <- f(m["vbd_backend_info"].Desc, m["vbd_backend_info"].Type, 1, dom, v.Device, fmt.Sprintf("%d", v.Major), fmt.Sprintf("%d", v.Minor), v.Mode, v.PhysicalDevice)
+				}
+			}
+		}
+		if g.opts.Networks {
+			ch <- f(m["nic_count"].Desc, m["nic_count"].Type, float64(domain.NumNICs), dom)
+			for n, v := range domain.NICs {
+				ch <- f(m["net_transmit_bytes_total"].Desc, m["net_transmit_bytes_total"].Type, float64(v.BytesTransmitted), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_receive_bytes_total"].Desc, m["net_receive_bytes_total"].Type, float64(v.BytesReceived), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_transmit_packets_total"].Desc, m["net_transmit_packets_total"].Type, float64(v.PacketsTransmitted), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_receive_packets_total"].Desc, m["net_receive_packets_total"].Type, float64(v.PacketsReceived), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_transmit_errors_total"].Desc, m["net_transmit_errors_total"].Type, float64(v.ErrorsTransmitted), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_receive_errors_total"].Desc, m["net_receive_errors_total"].Type, float64(v.ErrorsReceived), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_transmit_drops_total"].Desc, m["net_transmit_drops_total"].Type, float64(v.DropsTransmitted), dom, fmt.Sprintf("%d", n))
+				ch <- f(m["net_receive_drops_total"].Desc, m["net_receive_drops_total"].Type, float64(v.DropsReceived), dom, fmt.Sprintf("%d", n))
+			}
+		}
+		if g.opts.VCPUs {
+			for n, v := range domain.VCPUs {
+				online := 0.0
+				if v.Online {
+					online = 1.0
+				}
+				ch <- f(m["vcpu_seconds_total"].Desc, m["vcpu_seconds_total"].Type, v.Seconds, dom, fmt.Sprintf("%d", n))
+				ch <- f(m["vcpu_online"].Desc, m["vcpu_online"].Type, online, dom, fmt.Sprintf("%d", n))
+			}
 		}
 	}
 }
 
 func main() {
 	addr := flag.String("bind", ":8080", "The address to bind to")
+	vcpus := flag.Bool("collect-vcpus", true, "Export per-VCPU metrics for every domain")
+	networks := flag.Bool("collect-networks", true, "Export virtual network device metrics for every domain")
+	vbds := flag.Bool("collect-vbds", true, "Export virtual block device metrics for every domain")
+	pollTimeout := flag.Duration("poll-timeout", 10*time.Second, "Maximum time to wait for the Xen host on each scrape (0 to wait forever)")
+	backend := flag.String("backend", "libxenstat", "Where to get statistics from (libxenstat or xentop)")
+	xentopPath := flag.String("xentop-path", xenstat.DefaultXentopPath, "Path to the xentop program, for the xentop backend")
+	xentopIterations := flag.Int("xentop-iterations", 1, "Number of iterations xentop runs on each scrape, for the xentop backend")
+	xentopDelay := flag.Duration("xentop-delay", time.Second, "Delay between xentop iterations, for the xentop backend")
+	memory := flag.Bool("collect-memory-details", true, "Export the memory details of every domain found in xenstore, such as the balloon target")
+	netback := flag.Bool("collect-netback", false, "Export the detailed virtual network device statistics netback publishes in sysfs")
+	blkback := flag.Bool("collect-blkback", false, "Export the flush and discard statistics blkback publishes in sysfs")
+	sysfsRoot := flag.String("sysfs-root", sysfs.DefaultRoot, "Where sysfs is mounted")
+	xenstorePath := flag.String("xenstore-path", "", "Path to the xenstored socket or xenbus device used to complete domain information (for example "+xenstore.DefaultSocketPath+"); empty to disable")
+	record := flag.String("record", "", "Append every polled snapshot to this file, for later use with -replay")
+	replay := flag.String("replay", "", "Serve the snapshots recorded in this file instead of polling the Xen host")
+	replaySpeed := flag.Float64("replay-speed", 1, "Speed at which -replay plays the recording back (0 or less to advance one snapshot per scrape)")
+	lifetime := flag.Bool("collect-lifetime", false, "Export counters of every VM accumulated across domain restarts")
+	lifetimeStateFile := flag.String("lifetime-state-file", "", "File where the counters of -collect-lifetime are saved across restarts of the exporter; empty to keep them in memory only")
+	restartStateFile := flag.String("restart-state-file", "", "File where the restart and crash history of VMs is saved across restarts of the exporter; empty to keep it in memory only")
+	expectedDomainsFile := flag.String("expected-domains", "", "File listing the domains that should be running, one name or glob pattern per line, optionally followed by the states they must be in one of; empty to disable")
+	xlConfigDir := flag.String("xl-config-dir", "", "Directory of xl configuration files compared with the domains to detect configuration drift (for example "+xlconfig.DefaultConfigDir+"); empty to disable")
+	xlPath := flag.String("xl-path", "", "Path to the xl program, run on each scrape to get the configuration of domains to detect configuration drift; empty to disable")
+	labelBy := LabelByName
+	flag.Var(&labelBy, "label-by", "Domain attribute used as the dom label (name, domid or uuid); the per-VM restart and lifetime series always use the name, or the UUID with uuid")
 	flag.Parse()
 
-	prometheus.Register(NewXenCollector())
+	opts := CollectorOptions{
+		LabelBy:             labelBy,
+		VCPUs:               *vcpus,
+		Networks:            *networks,
+		VBDs:                *vbds,
+		PollTimeout:         *pollTimeout,
+		MemoryDetails:       *memory,
+		Netback:             *netback,
+		Blkback:             *blkback,
+		SysfsRoot:           *sysfsRoot,
+		XenstorePath:        *xenstorePath,
+		Lifetime:            *lifetime,
+		LifetimeStateFile:   *lifetimeStateFile,
+		RestartStateFile:    *restartStateFile,
+		ExpectedDomainsFile: *expectedDomainsFile,
+		XLConfigDir:         *xlConfigDir,
+		XLPath:              *xlPath,
+	}
+	if *blkback && !*vbds {
+		log.Fatalf("-collect-blkback needs -collect-vbds")
+	}
+	var open xenstat.Opener
+	switch {
+	case *replay != "":
+		open = xenstat.OpenReplay(*replay, *replaySpeed)
+	case *backend == "libxenstat":
+		if !xenstat.HaveLibxenstat {
+			log.Fatalf("This program was built without the libxenstat backend; use -backend=xentop")
+		}
+		open = xenstat.OpenXenStatsWith(xenstat.WithFlags(opts.Flags()))
+	case *backend == "xentop":
+		// xentop reports neither domain IDs nor UUIDs, which only
+		// xenstore can fill in.
+		if *xenstorePath == "" {
+			if labelBy != LabelByName {
+				log.Fatalf("-backend=xentop needs -xenstore-path with -label-by=%s", labelBy)
+			}
+			if *netback || *blkback {
+				log.Fatalf("-backend=xentop needs -xenstore-path with -collect-netback or -collect-blkback")
+			}
+		}
+		open = xenstat.OpenXentopBatch(*xentopPath, *xentopIterations, *xentopDelay)
+	default:
+		log.Fatalf("Unknown backend %q", *backend)
+	}
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("Cannot open recording: %s", err)
+		}
+		open = xenstat.RecordingOpener(open, f)
+	}
+	client := xenstat.NewClient(open)
+	prometheus.Register(NewXenCollector(client, opts))
 
 	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		h := promhttp.HandlerFor(prometheus.Gatherers{
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gather scrapes a collector of the domains with opts, failing the test
+// if the scrape would fail.
+func gather(t *testing.T, opts CollectorOptions, domains ...xenstat.DomainInfo) map[string][]map[string]string {
+	t.Helper()
+	src := xenstat.NewFakeSource(xenstat.FakeStep{Snapshot: xenstat.Snapshot{Domains: domains}})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewXenCollector(src, opts))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string][]map[string]string)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			l := make(map[string]string)
+			for _, p := range m.Label {
+				l[p.GetName()] = p.GetValue()
+			}
+			labels[mf.GetName()] = append(labels[mf.GetName()], l)
+		}
+	}
+	return labels
+}
+
+func TestLabelByUUIDDuringLocalMigration(t *testing.T) {
+	const uuid = "9c1d4e5a-0b7a-4a8e-9d2f-3f2b6e1c8a77"
+	series := gather(t, CollectorOptions{LabelBy: LabelByUUID},
+		xenstat.DomainInfo{DomainID: 3, Name: "web1--migratedaway", UUID: uuid},
+		xenstat.DomainInfo{DomainID: 4, Name: "web1--incoming", UUID: uuid},
+	)
+	var doms []string
+	for _, l := range series["xen_domain_info"] {
+		doms = append(doms, l["dom"])
+	}
+	if len(doms) != 2 || doms[0] == doms[1] {
+		t.Errorf("got dom labels %q, want two distinct ones", doms)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vmHistory is the persistent restart history of a VM.
+type vmHistory struct {
+	// DomainID is the domain the VM was last seen running as.
+	DomainID uint32
+	// CPUSeconds is the CPU time of that domain when last seen, used to
+	// notice restarts when the backend does not report domain IDs.
+	CPUSeconds float64
+	// Crashed is true if the domain was crashed when last seen.
+	Crashed bool
+	// StartTime is when the exporter first saw the domain.
+	StartTime time.Time
+	// LastSeen is when the VM was last seen running.
+	LastSeen time.Time
+	// Restarts counts the times the VM came back as a new domain.
+	Restarts uint64
+	// Crashes counts the times a domain of the VM entered the crashed state.
+	Crashes uint64
+}
+
+// restartState is what is saved in the state file.
+type restartState struct {
+	VMs map[string]*vmHistory
+}
+
+// restartTracker remembers the domain each VM runs as between scrapes,
+// to count restarts and crashes, and across restarts of the exporter if
+// it has a state file.  Restarts and crashes that come and go between two
+// scrapes are not seen.
+type restartTracker struct {
+	path  string
+	state restartState
+	mu    sync.Mutex
+}
+
+// newRestartTracker returns a tracker saving its state to path, loading
+// any state previously saved there.  If path is empty, the state is only
+// kept in memory.
+func newRestartTracker(path string) *restartTracker {
+	t := &restartTracker{path: path, state: restartState{VMs: make(map[string]*vmHistory)}}
+	if path == "" {
+		return t
+	}
+	var state restartState
+	if err := loadState(path, &state); err != nil {
+		log.Printf("Error reading restart state %s, starting afresh: %s", path, err)
+		return t
+	}
+	if state.VMs != nil {
+		t.state = state
+	}
+	return t
+}
+
+// update records a domain the VM is running as.
+func (h *vmHistory) update(d xenstat.DomainInfo, now time.Time) {
+	if h.DomainID != d.DomainID || d.CPUSeconds < h.CPUSeconds {
+		h.Restarts++
+		h.StartTime = now
+		h.Crashed = false
+	}
+	crashed := d.State.Has(xenstat.Crashed)
+	if crashed && !h.Crashed {
+		h.Crashes++
+	}
+	h.Crashed = crashed
+	h.DomainID = d.DomainID
+	h.CPUSeconds = d.CPUSeconds
+	h.LastSeen = now
+}
+
+// collectRestarts updates the history of the VMs the domains in the
+// snapshot run, and exports their restart and crash counts.  The dom
+// label is the VM key, since domain IDs change on every restart.
+func (g *XenCollector) collectRestarts(ch chan<- prometheus.Metric, snapshot xenstat.Snapshot) {
+	t := g.restarts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+	now := time.Now()
+	for _, domain := range snapshot.Domains {
+		key := g.vmKey(domain)
+		h, ok := t.state.VMs[key]
+		if !ok {
+			h = &vmHistory{DomainID: domain.DomainID, StartTime: now}
+			t.state.VMs[key] = h
+		}
+		h.update(domain, now)
+
+		ch <- f(m["domain_restarts_total"].Desc, m["domain_restarts_total"].Type, float64(h.Restarts), key)
+		ch <- f(m["domain_crashes_total"].Desc, m["domain_crashes_total"].Type, float64(h.Crashes), key)
+		ch <- f(m["domain_start_time_seconds"].Desc, m["domain_start_time_seconds"].Type, float64(h.StartTime.UnixNano())/1e9, key)
+	}
+
+	for key, h := range t.state.VMs {
+		if now.Sub(h.LastSeen) > stateExpiry {
+			delete(t.state.VMs, key)
+		}
+	}
+	if t.path != "" {
+		if err := saveState(t.path, t.state); err != nil {
+			log.Printf("Error saving restart state: %s", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Rudd-O/prometheus-xentop/sysfs"
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// netbackCounters maps the netback statistics to the metrics they are
+// exported as.  Directions are swapped so that, like the other net_*
+// metrics, they are from the point of view of the domain.
+var netbackCounters = map[string]string{
+	"rx_fifo_errors":    "net_transmit_fifo_errors_total",
+	"tx_fifo_errors":    "net_receive_fifo_errors_total",
+	"rx_frame_errors":   "net_transmit_frame_errors_total",
+	"multicast":         "net_transmit_multicast_packets_total",
+	"collisions":        "net_collisions_total",
+	"tx_carrier_errors": "net_receive_carrier_errors_total",
+}
+
+// collectNetback exports the netback statistics of the vifs of the
+// domains in the snapshot.
+func (g *XenCollector) collectNetback(ch chan<- prometheus.Metric, snapshot xenstat.Snapshot) {
+	vifs, err := sysfs.ReadVifs(g.opts.SysfsRoot)
+	if err != nil {
+		log.Printf("Error reading netback statistics: %s", err)
+		return
+	}
+
+	byID := make(map[uint32]xenstat.DomainInfo)
+	for _, d := range snapshot.Domains {
+		byID[d.DomainID] = d
+	}
+
+	f := prometheus.MustNewConstMetric
+	m := g.metrics
+	for _, v := range vifs {
+		domain, ok := byID[v.DomainID]
+		if !ok {
+			continue
+		}
+		dom := g.domLabel(domain)
+		// The nic label of the other net_* metrics is the position of
+		// the NIC in the domain, which is usually but not necessarily
+		// its device number.  Vifs the domain did not report, such as
+		// ones being unplugged, are skipped, since their device number
+		// could clash with the position of another NIC.
+		nic := fmt.Sprintf("%d", v.Device)
+		if g.opts.Networks {
+			found := false
+			for n, i := range domain.NICs {
+				if i.ID == v.Device {
+					nic = fmt.Sprintf("%d", n)
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		for stat, name := range netbackCounters {
+			if value, ok := v.Statistics[stat]; ok {
+				ch <- f(m[name].Desc, m[name].Type, float64(value), dom, nic)
+			}
+		}
+		carrier := 0.0
+		if v.Carrier {
+			carrier = 1.0
+		}
+		ch <- f(m["net_carrier"].Desc, m["net_carrier"].Type, carrier, dom, nic)
+		ch <- f(m["net_operstate"].Desc, m["net_operstate"].Type, 1, dom, nic, v.OperState)
+	}
+}
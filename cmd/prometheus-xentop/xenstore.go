@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sync"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat"
+	"github.com/Rudd-O/prometheus-xentop/xenstore"
+)
+
+// xenstoreEnricher fills in the DomainInfo fields that the backend could
+// not provide from xenstore, such as the domain IDs and UUIDs that the
+// xentop backend lacks, shutdown reasons, and optionally the memory
+// details of domains.  The connection to xenstore is opened lazily and
+// reopened after errors.
+type xenstoreEnricher struct {
+	path   string
+	memory bool
+	c      *xenstore.Client
+	mu     sync.Mutex
+}
+
+func newXenstoreEnricher(path string, memory bool) *xenstoreEnricher {
+	return &xenstoreEnricher{path: path, memory: memory}
+}
+
+// client returns the connection to xenstore.  The caller must hold the lock.
+func (e *xenstoreEnricher) client() (*xenstore.Client, error) {
+	if e.c == nil {
+		c, err := xenstore.Open(e.path)
+		if err != nil {
+			return nil, err
+		}
+		e.c = c
+	}
+	return e.c, nil
+}
+
+// fail drops the connection to xenstore.  The caller must hold the lock.
+func (e *xenstoreEnricher) fail(err error) {
+	log.Printf("Error reading xenstore: %s", err)
+	if e.c != nil {
+		e.c.Close()
+		e.c = nil
+	}
+}
+
+// Enrich fills in the missing identity, shutdown reason and memory
+// details of the domains.
+func (e *xenstoreEnricher) Enrich(domains []xenstat.DomainInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.client()
+	if err != nil {
+		e.fail(err)
+		return
+	}
+
+	ids, err := c.Directory("/local/domain")
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	byName := make(map[string]string)
+	for _, id := range ids {
+		name, err := c.Read(fmt.Sprintf("/local/domain/%s/name", id))
+		if err == xenstore.ENOENT {
+			continue
+		} else if err != nil {
+			e.fail(err)
+			return
+		}
+		byName[name] = id
+	}
+
+	read := func(p string) (string, bool) {
+		v, err := c.Read(p)
+		return v, err == nil
+	}
+	for i := range domains {
+		d := &domains[i]
+		id, ok := byName[d.Name]
+		if !ok {
+			continue
+		}
+		var domid uint32
+		if _, err := fmt.Sscanf(id, "%d", &domid); err != nil {
+			continue
+		}
+		if e.memory && d.Memory == (xenstat.MemoryDetails{}) {
+			d.Memory = xenstat.ReadMemoryDetails(domid, read)
+		}
+		if d.State&(xenstat.Shutdown|xenstat.Crashed) != 0 && d.ShutdownReason == "" {
+			d.ShutdownReason = xenstat.ReadShutdownReason(domid, read)
+			if d.ShutdownReason == "" && d.State.Has(xenstat.Crashed) {
+				d.ShutdownReason = "crash"
+			}
+		}
+		if d.UUID != "" {
+			continue
+		}
+		d.DomainID = domid
+		vm, err := c.Read(fmt.Sprintf("/local/domain/%s/vm", id))
+		if err == xenstore.ENOENT {
+			continue
+		} else if err != nil {
+			e.fail(err)
+			return
+		}
+		d.UUID = path.Base(vm)
+	}
+}
@@ -0,0 +1,134 @@
+package sysfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat/xeninfo"
+)
+
+// vbdName matches the names blkback gives to its xenbus devices.
+var vbdName = regexp.MustCompile(`^vbd-([0-9]+)-([0-9]+)$`)
+
+// VBD holds the statistics of a blkback virtual block device.
+type VBD struct {
+	// DomainID is the ID of the domain the device belongs to.
+	DomainID uint32
+	// Dev is the virtual device number of the device, as seen by the domain.
+	Dev uint32
+	// Mode is "r" for read-only devices and "w" for writable ones.
+	Mode string
+	// PhysicalDevice is the major:minor number, in hexadecimal, of the
+	// dom0 device backing the virtual device.
+	PhysicalDevice string
+	// Statistics holds every counter in the statistics directory of the
+	// device, keyed by file name, such as f_req.
+	Statistics map[string]uint64
+}
+
+// ReadVBDs returns the statistics of every blkback device found under
+// root/bus/xen-backend/devices, sorted by domain ID and device.  Devices
+// that vanish while being read are skipped.
+func ReadVBDs(root string) ([]VBD, error) {
+	devdir := filepath.Join(root, "bus", "xen-backend", "devices")
+	entries, err := ioutil.ReadDir(devdir)
+	if err != nil {
+		return nil, err
+	}
+
+	vbds := []VBD{}
+	for _, e := range entries {
+		m := vbdName.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		domid, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		dev, err := strconv.ParseUint(m[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(devdir, e.Name())
+		stats, err := readCounters(filepath.Join(dir, "statistics"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		mode, _ := readString(filepath.Join(dir, "mode"))
+		physical, _ := readString(filepath.Join(dir, "physical_device"))
+		vbds = append(vbds, VBD{
+			DomainID:       uint32(domid),
+			Dev:            uint32(dev),
+			Mode:           mode,
+			PhysicalDevice: physical,
+			Statistics:     stats,
+		})
+	}
+	sort.Slice(vbds, func(i, j int) bool {
+		if vbds[i].DomainID != vbds[j].DomainID {
+			return vbds[i].DomainID < vbds[j].DomainID
+		}
+		return vbds[i].Dev < vbds[j].Dev
+	})
+	return vbds, nil
+}
+
+// FillVBDs completes the VBDInfo of the domains with the blkback
+// statistics found under root.  Devices are matched by domain ID and
+// virtual device number.  The counters libxenstat also reports are
+// overwritten, since blkback is where libxenstat reads them from anyway.
+func FillVBDs(root string, domains []xeninfo.DomainInfo) error {
+	vbds, err := ReadVBDs(root)
+	if err != nil {
+		return err
+	}
+	type key struct {
+		domid uint32
+		dev   uint32
+	}
+	byKey := make(map[key]VBD)
+	for _, v := range vbds {
+		byKey[key{v.DomainID, v.Dev}] = v
+	}
+
+	for i := range domains {
+		for j := range domains[i].VBDs {
+			info := &domains[i].VBDs[j]
+			v, ok := byKey[key{domains[i].DomainID, info.Dev}]
+			if !ok {
+				continue
+			}
+			if value, ok := v.Statistics["oo_req"]; ok {
+				info.OutOfRequests = value
+			}
+			if value, ok := v.Statistics["rd_req"]; ok {
+				info.ReadRequests = value
+			}
+			if value, ok := v.Statistics["wr_req"]; ok {
+				info.WriteRequests = value
+			}
+			if value, ok := v.Statistics["f_req"]; ok {
+				info.FlushRequests = value
+			}
+			if value, ok := v.Statistics["ds_req"]; ok {
+				info.DiscardRequests = value
+			}
+			if value, ok := v.Statistics["rd_sect"]; ok {
+				info.BytesRead = value * 512
+			}
+			if value, ok := v.Statistics["wr_sect"]; ok {
+				info.BytesWritten = value * 512
+			}
+			info.Mode = v.Mode
+			info.PhysicalDevice = v.PhysicalDevice
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package sysfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat/xeninfo"
+)
+
+func TestReadVBDs(t *testing.T) {
+	vbds, err := ReadVBDs("testdata/sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range vbds {
+		got = append(got, v.Mode+" "+v.PhysicalDevice)
+	}
+	// vbd-5-51712 has no statistics directory, and vif-3-0 is not a vbd.
+	if want := []string{"w fd:3", "r fd:4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFillVBDs(t *testing.T) {
+	domains := []xeninfo.DomainInfo{
+		{DomainID: 3, VBDs: []xeninfo.VBDInfo{{Dev: 51712}, {Dev: 51728, ReadRequests: 1}}},
+		{DomainID: 4, VBDs: []xeninfo.VBDInfo{{Dev: 51712}}},
+		{DomainID: 6, VBDs: []xeninfo.VBDInfo{{Dev: 51712, ReadRequests: 1}}},
+	}
+	if err := FillVBDs("testdata/sys", domains); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]xeninfo.VBDInfo{
+		{
+			{
+				Dev:             51712,
+				OutOfRequests:   1,
+				ReadRequests:    100,
+				WriteRequests:   200,
+				FlushRequests:   3,
+				DiscardRequests: 4,
+				BytesRead:       800 * 512,
+				BytesWritten:    1600 * 512,
+				Mode:            "w",
+				PhysicalDevice:  "fd:3",
+			},
+			// Not in sysfs, so left alone.
+			{Dev: 51728, ReadRequests: 1},
+		},
+		// Same device number as above, but of another domain.
+		{{Dev: 51712, ReadRequests: 9, Mode: "r", PhysicalDevice: "fd:4"}},
+		{{Dev: 51712, ReadRequests: 1}},
+	}
+	for i, d := range domains {
+		if !reflect.DeepEqual(d.VBDs, want[i]) {
+			t.Errorf("domain %d: got %+v\nwant %+v", d.DomainID, d.VBDs, want[i])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package sysfs reads the statistics that the Xen backend drivers of dom0
+// publish in sysfs, which are more detailed than what libxenstat reports.
+//
+// Every reader takes the root of the sysfs tree as argument, so they can
+// be pointed at a copy of the tree for testing.
+package sysfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultRoot is where sysfs is mounted.
+const DefaultRoot = "/sys"
+
+// vifName matches the names netback gives to paravirtual interfaces.
+// Interfaces of emulated network cards (vifD.N-emu) are not matched.
+var vifName = regexp.MustCompile(`^vif([0-9]+)\.([0-9]+)$`)
+
+// Vif holds the statistics of a netback virtual interface.  All counters
+// are as seen from dom0, so bytes received by the vif were transmitted by
+// the domain.
+type Vif struct {
+	// Name is the name of the interface in dom0, such as vif3.0.
+	Name string
+	// DomainID is the ID of the domain the interface belongs to.
+	DomainID uint32
+	// Device is the device number of the interface within the domain.
+	Device uint32
+	// OperState is the operational state of the interface, such as "up".
+	OperState string
+	// Carrier is true when the interface has a carrier.
+	Carrier bool
+	// Statistics holds every counter in the statistics directory of the
+	// interface, keyed by file name, such as rx_fifo_errors.
+	Statistics map[string]uint64
+}
+
+// readString returns the trimmed contents of a sysfs attribute.
+func readString(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readUint returns the contents of a numeric sysfs attribute.
+func readUint(path string) (uint64, error) {
+	s, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// readCounters reads every numeric attribute in dir.
+func readCounters(dir string) (map[string]uint64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]uint64)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		v, err := readUint(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		counters[e.Name()] = v
+	}
+	return counters, nil
+}
+
+// ReadVifs returns the statistics of every netback interface found under
+// root/class/net, sorted by domain ID and device.  Interfaces that vanish
+// while being read are skipped.
+func ReadVifs(root string) ([]Vif, error) {
+	netdir := filepath.Join(root, "class", "net")
+	entries, err := ioutil.ReadDir(netdir)
+	if err != nil {
+		return nil, err
+	}
+
+	vifs := []Vif{}
+	for _, e := range entries {
+		m := vifName.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		domid, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		device, err := strconv.ParseUint(m[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(netdir, e.Name())
+		stats, err := readCounters(filepath.Join(dir, "statistics"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		operstate, _ := readString(filepath.Join(dir, "operstate"))
+		// Reading carrier fails with EINVAL while the interface is down.
+		carrier, _ := readUint(filepath.Join(dir, "carrier"))
+		vifs = append(vifs, Vif{
+			Name:       e.Name(),
+			DomainID:   uint32(domid),
+			Device:     uint32(device),
+			OperState:  operstate,
+			Carrier:    carrier == 1,
+			Statistics: stats,
+		})
+	}
+	sort.Slice(vifs, func(i, j int) bool {
+		if vifs[i].DomainID != vifs[j].DomainID {
+			return vifs[i].DomainID < vifs[j].DomainID
+		}
+		return vifs[i].Device < vifs[j].Device
+	})
+	return vifs, nil
+}
@@ -0,0 +1,43 @@
+package sysfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadVifs(t *testing.T) {
+	vifs, err := ReadVifs("testdata/sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// vif3.0-emu belongs to an emulated card, eth0 is not a vif, and
+	// vif4.0 has no statistics directory.
+	want := []Vif{
+		{
+			Name:       "vif3.0",
+			DomainID:   3,
+			Device:     0,
+			OperState:  "up",
+			Carrier:    true,
+			Statistics: map[string]uint64{"rx_fifo_errors": 2, "tx_carrier_errors": 1, "multicast": 7},
+		},
+		{
+			// carrier cannot be read while the interface is down.
+			Name:       "vif3.1",
+			DomainID:   3,
+			Device:     1,
+			OperState:  "down",
+			Carrier:    false,
+			Statistics: map[string]uint64{"collisions": 0},
+		},
+	}
+	if !reflect.DeepEqual(vifs, want) {
+		t.Errorf("got %+v\nwant %+v", vifs, want)
+	}
+}
+
+func TestReadVifsMissingRoot(t *testing.T) {
+	if _, err := ReadVifs("testdata/missing"); err == nil {
+		t.Error("expected an error for a missing sysfs tree")
+	}
+}
@@ -0,0 +1,202 @@
+package xenstat
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ConnectionState describes whether a Client is connected to its Source.
+type ConnectionState int
+
+const (
+	// Disconnected means the Client will attempt to connect on the next poll
+	// once its backoff period has elapsed.
+	Disconnected ConnectionState = iota
+	// Connected means the last connection attempt or poll succeeded.
+	Connected
+	// Closed means Close() was called, and the Client cannot be used anymore.
+	Closed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultMinBackoff is the delay after the first failed connection attempt
+	// or poll.
+	DefaultMinBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the longest delay between connection attempts.
+	DefaultMaxBackoff = time.Minute
+)
+
+// Client is a Source that manages the lifecycle of another Source.  It
+// connects lazily, and whenever the underlying Source fails to poll, it is
+// closed and a new one is opened on a later poll.  Failed connection
+// attempts and failed polls, including polls that time out, are retried
+// with exponential backoff, which is reset by the first successful poll;
+// while backing off, or while the previous Source is still busy with a
+// poll it abandoned, polls return ErrDisconnected without attempting to
+// connect.
+//
+// This code is thread-safe.
+type Client struct {
+	open       Opener
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	src        Source
+	// retired is the last Source closed, which must be done with its
+	// calls before another one is opened.
+	retired     Source
+	state       ConnectionState
+	everOpened  bool
+	reconnects  uint64
+	failures    uint
+	nextAttempt time.Time
+	mu          sync.Mutex
+}
+
+// NewClient returns a Client that uses open to connect, with the default
+// backoff settings.
+func NewClient(open Opener) *Client {
+	return &Client{
+		open:       open,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// SetBackoff changes the minimum and maximum delay between connection
+// attempts.
+func (c *Client) SetBackoff(min, max time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.minBackoff = min
+	c.maxBackoff = max
+}
+
+// State returns the current connection state.
+func (c *Client) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Reconnects returns how many times the Client has successfully connected
+// after its first connection.
+func (c *Client) Reconnects() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnects
+}
+
+// backoff returns the delay before the next connection attempt.
+// The caller must hold the lock.
+func (c *Client) backoff() time.Duration {
+	d := c.minBackoff
+	for i := uint(1); i < c.failures && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d
+}
+
+// connect opens the underlying Source if needed.  The caller must hold
+// the lock.
+func (c *Client) connect() error {
+	if c.src != nil {
+		return nil
+	}
+	if time.Now().Before(c.nextAttempt) {
+		return ErrDisconnected
+	}
+	// A poll abandoned on timeout may still hold on to xend; opening
+	// another connection alongside it would only pile up hung calls.
+	if c.retired != nil {
+		if isBusy(c.retired) {
+			return ErrDisconnected
+		}
+		c.retired = nil
+	}
+	src, err := c.open()
+	if err != nil {
+		c.fail()
+		return err
+	}
+	if c.everOpened {
+		c.reconnects++
+	}
+	c.everOpened = true
+	c.src = src
+	c.state = Connected
+	return nil
+}
+
+// fail disconnects and delays the next connection attempt.  The caller
+// must hold the lock.
+func (c *Client) fail() {
+	c.disconnect()
+	c.failures++
+	c.nextAttempt = time.Now().Add(c.backoff())
+}
+
+// disconnect closes the underlying Source.  The caller must hold the lock.
+func (c *Client) disconnect() {
+	if c.src != nil {
+		c.src.Close()
+		c.retired = c.src
+		c.src = nil
+	}
+	c.state = Disconnected
+}
+
+// PollSnapshot polls the underlying Source, connecting first if necessary.
+// If the poll fails, the error is returned and the underlying Source is
+// replaced once the backoff period has elapsed.
+func (c *Client) PollSnapshot() (Snapshot, error) {
+	return c.PollContext(context.Background())
+}
+
+// PollContext is like PollSnapshot, but gives up once ctx is done.  See
+// the PollContext function for how ctx is honored.
+func (c *Client) PollContext(ctx context.Context) (Snapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == Closed {
+		return Snapshot{}, ErrDisconnected
+	}
+	if err := c.connect(); err != nil {
+		return Snapshot{}, err
+	}
+	s, err := PollContext(ctx, c.src)
+	if err != nil {
+		c.fail()
+		return Snapshot{}, err
+	}
+	c.failures = 0
+	c.nextAttempt = time.Time{}
+	return s, nil
+}
+
+// Close releases the underlying Source.  Subsequent polls return
+// ErrDisconnected.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnect()
+	c.state = Closed
+}
+
+var _ ContextSource = (*Client)(nil)
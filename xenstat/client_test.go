@@ -0,0 +1,94 @@
+package xenstat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// A Source that opens fine but fails every poll must not be reopened on
+// every poll.
+func TestClientBacksOffAfterPollFailures(t *testing.T) {
+	errPoll := errors.New("poll failed")
+	opened := 0
+	c := NewClient(func() (Source, error) {
+		opened++
+		return NewFakeSource(FakeStep{Err: errPoll}), nil
+	})
+	c.SetBackoff(time.Hour, time.Hour)
+
+	if _, err := c.PollSnapshot(); err != errPoll {
+		t.Fatalf("first poll: got %v, want %v", err, errPoll)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.PollSnapshot(); err != ErrDisconnected {
+			t.Fatalf("poll while backing off: got %v, want ErrDisconnected", err)
+		}
+	}
+	if opened != 1 {
+		t.Errorf("source opened %d times, want 1", opened)
+	}
+	if r := c.Reconnects(); r != 0 {
+		t.Errorf("got %d reconnects, want 0", r)
+	}
+}
+
+func TestClientBackoffResetsAfterSuccessfulPoll(t *testing.T) {
+	src := NewFakeSource(FakeStep{Err: ErrDisconnected}, FakeStep{})
+	c := NewClient(func() (Source, error) { return src, nil })
+	c.SetBackoff(time.Millisecond, time.Millisecond)
+
+	if _, err := c.PollSnapshot(); err != ErrDisconnected {
+		t.Fatalf("first poll: got %v, want ErrDisconnected", err)
+	}
+	// The FakeSource is closed by the Client, so reopen a fresh one.
+	src = NewFakeSource()
+	time.Sleep(2 * time.Millisecond)
+	if _, err := c.PollSnapshot(); err != nil {
+		t.Fatalf("poll after backoff: %v", err)
+	}
+	if c.State() != Connected || c.failures != 0 {
+		t.Errorf("got state %s with %d failures, want connected with none", c.State(), c.failures)
+	}
+	if r := c.Reconnects(); r != 1 {
+		t.Errorf("got %d reconnects, want 1", r)
+	}
+}
+
+// busyFake is a FakeSource that stays busy after Close until released.
+type busyFake struct {
+	*FakeSource
+	released chan struct{}
+}
+
+func (b busyFake) busy() bool {
+	select {
+	case <-b.released:
+		return false
+	default:
+		return true
+	}
+}
+
+func TestClientWaitsForBusySource(t *testing.T) {
+	released := make(chan struct{})
+	opened := 0
+	c := NewClient(func() (Source, error) {
+		opened++
+		return busyFake{NewFakeSource(FakeStep{Err: ErrDisconnected}), released}, nil
+	})
+	c.SetBackoff(time.Millisecond, time.Millisecond)
+
+	if _, err := c.PollSnapshot(); err != ErrDisconnected {
+		t.Fatalf("first poll: got %v, want ErrDisconnected", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := c.PollSnapshot(); err != ErrDisconnected || opened != 1 {
+		t.Fatalf("poll while busy: got %v after %d opens, want ErrDisconnected after 1", err, opened)
+	}
+	close(released)
+	c.PollSnapshot()
+	if opened != 2 {
+		t.Errorf("source opened %d times once released, want 2", opened)
+	}
+}
@@ -0,0 +1,15 @@
+package xenstat
+
+import (
+	"errors"
+)
+
+// ErrDisconnected happens when xend disconnects.
+var ErrDisconnected = errors.New("not connected to xend")
+
+// ErrCannotConnect happens when xend is not available.
+var ErrCannotConnect = errors.New("cannot connect to xend")
+
+// ErrNotSupported happens when the libxenstat backend is not available
+// because the program was built without cgo or the libxenstat build tag.
+var ErrNotSupported = errors.New("libxenstat support was not built in")
@@ -0,0 +1,214 @@
+package xenstat
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// EventType is the kind of change an Event reports.
+type EventType int
+
+const (
+	// DomainCreated is sent when a domain appears.
+	DomainCreated EventType = iota
+	// DomainDestroyed is sent when a domain disappears.
+	DomainDestroyed
+	// StateChanged is sent when the state flags of a domain change.
+	StateChanged
+	// VBDAdded is sent when a virtual block device is attached to a domain.
+	VBDAdded
+	// VBDRemoved is sent when a virtual block device is detached from a domain.
+	VBDRemoved
+	// NICAdded is sent when a virtual NIC is attached to a domain.
+	NICAdded
+	// NICRemoved is sent when a virtual NIC is detached from a domain.
+	NICRemoved
+	// VCPUCountChanged is sent when the number of VCPUs of a domain changes.
+	VCPUCountChanged
+)
+
+// EventTypes lists every EventType.
+var EventTypes = []EventType{
+	DomainCreated, DomainDestroyed, StateChanged,
+	VBDAdded, VBDRemoved, NICAdded, NICRemoved, VCPUCountChanged,
+}
+
+var eventTypeNames = map[EventType]string{
+	DomainCreated:    "domain_created",
+	DomainDestroyed:  "domain_destroyed",
+	StateChanged:     "state_changed",
+	VBDAdded:         "vbd_added",
+	VBDRemoved:       "vbd_removed",
+	NICAdded:         "nic_added",
+	NICRemoved:       "nic_removed",
+	VCPUCountChanged: "vcpu_count_changed",
+}
+
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// Event describes a change between two snapshots.
+type Event struct {
+	// Type is the kind of change.
+	Type EventType
+	// Domain is the domain that changed, as last seen.  For
+	// DomainDestroyed, it is the domain as it was before disappearing.
+	Domain DomainInfo
+	// OldState and NewState are the state flags before and after a
+	// StateChanged event.
+	OldState DomainState
+	NewState DomainState
+	// VBD is the device of a VBDAdded or VBDRemoved event.
+	VBD VBDInfo
+	// NIC is the device of a NICAdded or NICRemoved event.
+	NIC NICInfo
+	// OldVCPUs and NewVCPUs are the number of VCPUs before and after a
+	// VCPUCountChanged event.
+	OldVCPUs uint32
+	NewVCPUs uint32
+}
+
+// domainKey identifies a domain across snapshots.  The name is part of it
+// because the xentop backend does not report domain IDs.
+type domainKey struct {
+	id   uint32
+	name string
+}
+
+func keyOf(d DomainInfo) domainKey {
+	return domainKey{d.DomainID, d.Name}
+}
+
+// DiffSnapshots returns the events that happened between prev and cur.
+// Domains are matched by domain ID and name, so a domain that was
+// restarted shows up as destroyed and created.  Devices are matched by
+// their identifiers, and are only compared if the domain had devices of
+// that kind in either snapshot, since backends that do not collect them
+// omit them from both; this way, removing the last device of a domain is
+// still noticed.
+func DiffSnapshots(prev, cur Snapshot) []Event {
+	var events []Event
+
+	prevDomains := make(map[domainKey]DomainInfo)
+	for _, d := range prev.Domains {
+		prevDomains[keyOf(d)] = d
+	}
+	seen := make(map[domainKey]bool)
+	for _, d := range cur.Domains {
+		seen[keyOf(d)] = true
+	}
+	for _, d := range prev.Domains {
+		if !seen[keyOf(d)] {
+			events = append(events, Event{Type: DomainDestroyed, Domain: d})
+		}
+	}
+
+	for _, d := range cur.Domains {
+		p, ok := prevDomains[keyOf(d)]
+		if !ok {
+			events = append(events, Event{Type: DomainCreated, Domain: d})
+			continue
+		}
+		if p.State != d.State {
+			events = append(events, Event{Type: StateChanged, Domain: d, OldState: p.State, NewState: d.State})
+		}
+		if p.NumVCPUs != d.NumVCPUs {
+			events = append(events, Event{Type: VCPUCountChanged, Domain: d, OldVCPUs: p.NumVCPUs, NewVCPUs: d.NumVCPUs})
+		}
+
+		if len(p.VBDs) > 0 || len(d.VBDs) > 0 {
+			prevVBDs := make(map[uint32]bool)
+			for _, v := range p.VBDs {
+				prevVBDs[v.Dev] = true
+			}
+			curVBDs := make(map[uint32]bool)
+			for _, v := range d.VBDs {
+				curVBDs[v.Dev] = true
+				if !prevVBDs[v.Dev] {
+					events = append(events, Event{Type: VBDAdded, Domain: d, VBD: v})
+				}
+			}
+			for _, v := range p.VBDs {
+				if !curVBDs[v.Dev] {
+					events = append(events, Event{Type: VBDRemoved, Domain: d, VBD: v})
+				}
+			}
+		}
+
+		if len(p.NICs) > 0 || len(d.NICs) > 0 {
+			prevNICs := make(map[uint32]bool)
+			for _, v := range p.NICs {
+				prevNICs[v.ID] = true
+			}
+			curNICs := make(map[uint32]bool)
+			for _, v := range d.NICs {
+				curNICs[v.ID] = true
+				if !prevNICs[v.ID] {
+					events = append(events, Event{Type: NICAdded, Domain: d, NIC: v})
+				}
+			}
+			for _, v := range p.NICs {
+				if !curNICs[v.ID] {
+					events = append(events, Event{Type: NICRemoved, Domain: d, NIC: v})
+				}
+			}
+		}
+	}
+
+	return events
+}
+
+// Watch polls src every interval, and sends the events that happened
+// between consecutive polls on the returned channel.  The first poll only
+// serves as a baseline.  Polls that fail are logged and skipped, so the
+// next successful poll reports everything that changed in the meantime;
+// wrap the source in a Client for it to reconnect after failures.
+//
+// Polling pauses while the receiver is not keeping up.  The channel is
+// closed once ctx is done.
+func Watch(ctx context.Context, src Source, interval time.Duration) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var prev *Snapshot
+		for {
+			cur, err := PollContext(ctx, src)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Error polling for events: %s", err)
+			} else {
+				if prev != nil {
+					for _, e := range DiffSnapshots(*prev, cur) {
+						select {
+						case ch <- e:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+				prev = &cur
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Watch is like the Watch function, polling this XenStats instance.
+// Since a XenStats instance cannot be used after a disconnection, no more
+// events are sent after one.
+func (x *XenStats) Watch(ctx context.Context, interval time.Duration) <-chan Event {
+	return Watch(ctx, x, interval)
+}
@@ -0,0 +1,29 @@
+package xenstat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSnapshotsDevices(t *testing.T) {
+	prev := Snapshot{Domains: []DomainInfo{
+		{DomainID: 1, Name: "web1", NICs: []NICInfo{{ID: 0}}},
+		{DomainID: 2, Name: "db1"},
+	}}
+	cur := Snapshot{Domains: []DomainInfo{
+		{DomainID: 1, Name: "web1", VBDs: []VBDInfo{{Dev: 51712}}},
+		{DomainID: 2, Name: "db1"},
+	}}
+	var got []EventType
+	for _, ev := range DiffSnapshots(prev, cur) {
+		if ev.Domain.Name != "web1" {
+			t.Errorf("got %s event for %s", ev.Type, ev.Domain.Name)
+		}
+		got = append(got, ev.Type)
+	}
+	// The first VBD and the removal of the last NIC are both noticed,
+	// while db1, which lists no devices at all, has no events.
+	if want := []EventType{VBDAdded, NICRemoved}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package xenstat
+
+import (
+	"sync"
+)
+
+// FakeStep is one scripted result of FakeSource.Poll.
+type FakeStep struct {
+	// Snapshot is returned by PollSnapshot when Err is nil.
+	Snapshot
+	// Err, if not nil, is returned by Poll instead of the Snapshot.
+	// Scripting ErrDisconnected simulates xend going away.
+	Err error
+}
+
+// FakeSource is an in-memory Source that returns a scripted sequence of
+// poll results.  Once the script is exhausted, the last step is repeated
+// indefinitely.  A FakeSource with no steps returns no domains.
+//
+// After Close() has been called, the FakeSource behaves like a disconnected
+// XenStats and only returns ErrDisconnected.
+//
+// This code is thread-safe.
+type FakeSource struct {
+	steps  []FakeStep
+	pos    int
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewFakeSource returns a FakeSource that will return steps in order.
+func NewFakeSource(steps ...FakeStep) *FakeSource {
+	return &FakeSource{steps: steps}
+}
+
+// Push appends steps to the script.
+func (f *FakeSource) Push(steps ...FakeStep) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.steps = append(f.steps, steps...)
+}
+
+// Poll returns the domains of the next scripted step.
+func (f *FakeSource) Poll() ([]DomainInfo, error) {
+	s, err := f.PollSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return s.Domains, nil
+}
+
+// PollSnapshot returns a copy of the next scripted step.
+func (f *FakeSource) PollSnapshot() (Snapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return Snapshot{}, ErrDisconnected
+	}
+	if len(f.steps) == 0 {
+		return Snapshot{Domains: []DomainInfo{}}, nil
+	}
+
+	step := f.steps[f.pos]
+	if f.pos < len(f.steps)-1 {
+		f.pos++
+	}
+	if step.Err != nil {
+		return Snapshot{}, step.Err
+	}
+	return copySnapshot(step.Snapshot), nil
+}
+
+// Close marks the FakeSource as disconnected.
+func (f *FakeSource) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+}
+
+var _ Source = (*FakeSource)(nil)
@@ -0,0 +1,63 @@
+package xenstat
+
+import (
+	"testing"
+	"time"
+)
+
+func testSnapshot() Snapshot {
+	return Snapshot{
+		PCPUs: []PCPUInfo{{}},
+		Domains: []DomainInfo{{
+			Name:  "web1",
+			VBDs:  []VBDInfo{{Dev: 51712}},
+			NICs:  []NICInfo{{ID: 0}},
+			VCPUs: []VCPUInfo{{Online: true}},
+		}},
+	}
+}
+
+// mutate changes s the way Enrich and FillVBDs do.
+func mutate(s Snapshot) {
+	d := &s.Domains[0]
+	d.UUID = "changed"
+	d.VBDs[0].Device = "changed"
+	d.NICs[0].ID = 9
+	d.VCPUs[0].Online = false
+	s.PCPUs[0].BusySeconds = 9
+}
+
+// checkUnchanged fails unless s is still what testSnapshot returns.
+func checkUnchanged(t *testing.T, s Snapshot) {
+	t.Helper()
+	d := s.Domains[0]
+	if d.UUID != "" || d.VBDs[0].Device != "" || d.NICs[0].ID != 0 || !d.VCPUs[0].Online || s.PCPUs[0].BusySeconds != 0 {
+		t.Errorf("snapshot changed by a previous consumer: %+v", s)
+	}
+}
+
+func TestFakeSourceCopiesSnapshots(t *testing.T) {
+	src := NewFakeSource(FakeStep{Snapshot: testSnapshot()})
+	for i := 0; i < 2; i++ {
+		s, err := src.PollSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkUnchanged(t, s)
+		mutate(s)
+	}
+}
+
+func TestReplayCopiesSnapshots(t *testing.T) {
+	for _, speed := range []float64{0, 1} {
+		src := NewReplay([]Record{{Time: time.Now(), Snapshot: testSnapshot()}}, speed)
+		for i := 0; i < 2; i++ {
+			s, err := src.PollSnapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkUnchanged(t, s)
+			mutate(s)
+		}
+	}
+}
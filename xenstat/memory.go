@@ -0,0 +1,74 @@
+package xenstat
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// readKiB reads a xenstore value expressed in KiB, and returns it in bytes.
+func readKiB(read func(path string) (string, bool), path string) uint64 {
+	v, ok := read(path)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n * 1024
+}
+
+// parseMeminfo parses the memory usage a guest reports in xenstore.
+// Qubes OS guests report either a single number, the used memory in KiB,
+// or, in older releases, lines in the format of /proc/meminfo.  Reports
+// that cannot be understood are ignored.
+func parseMeminfo(s string, m *MemoryDetails) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		m.GuestUsedBytes = n * 1024
+		return
+	}
+
+	fields := make(map[string]uint64)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		fields[strings.TrimSuffix(f[0], ":")] = n * 1024
+	}
+	total, ok := fields["MemTotal"]
+	if !ok {
+		return
+	}
+	free := fields["MemFree"]
+	cached := fields["Buffers"] + fields["Cached"]
+	if free+cached <= total {
+		m.GuestUsedBytes = total - free - cached
+	}
+	m.GuestFreeBytes = free
+	m.GuestCachedBytes = cached
+}
+
+// ReadMemoryDetails reads the memory details of domain domid from
+// xenstore.  read returns the value at a xenstore path, and false if it
+// cannot be read.  Values that cannot be read are left as zero.
+func ReadMemoryDetails(domid uint32, read func(path string) (string, bool)) MemoryDetails {
+	base := fmt.Sprintf("/local/domain/%d/memory/", domid)
+	m := MemoryDetails{
+		TargetBytes:    readKiB(read, base+"target"),
+		StaticMaxBytes: readKiB(read, base+"static-max"),
+		VideoRAMBytes:  readKiB(read, base+"videoram"),
+	}
+	if v, ok := read(base + "meminfo"); ok {
+		parseMeminfo(v, &m)
+	}
+	return m
+}
@@ -0,0 +1,40 @@
+package xenstat
+
+// Flags selects which optional data XenStats collects on each poll.
+// Leaving out data that is not needed makes polls considerably cheaper
+// on hosts with many domains.
+type Flags uint
+
+const (
+	// CollectVCPUs fills DomainInfo.VCPUs.
+	CollectVCPUs Flags = 1 << iota
+	// CollectNetworks fills DomainInfo.NICs and DomainInfo.NumNICs.
+	CollectNetworks
+	// CollectVBDs fills DomainInfo.VBDs and DomainInfo.NumVBDs.
+	CollectVBDs
+	// CollectXenVersion fills NodeInfo.XenVersion.
+	CollectXenVersion
+	// CollectMemoryDetails fills DomainInfo.Memory from xenstore.
+	CollectMemoryDetails
+
+	// CollectAll collects everything.  This is the default.
+	CollectAll = CollectVCPUs | CollectNetworks | CollectVBDs | CollectXenVersion | CollectMemoryDetails
+)
+
+type options struct {
+	flags Flags
+}
+
+func defaultOptions() options {
+	return options{flags: CollectAll}
+}
+
+// Option configures a XenStats instance.
+type Option func(*options)
+
+// WithFlags selects which optional data is collected on each poll.
+func WithFlags(f Flags) Option {
+	return func(o *options) {
+		o.flags = f
+	}
+}
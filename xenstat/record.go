@@ -0,0 +1,191 @@
+package xenstat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is a snapshot with the time it was taken.  Recordings are made
+// of one JSON-encoded Record per line.
+type Record struct {
+	// Time is when the snapshot was taken.
+	Time time.Time
+	// Snapshot is the result of the poll.
+	Snapshot Snapshot
+}
+
+// recordLog serialises the records of every Recorder writing to the same
+// destination.
+type recordLog struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func (l *recordLog) write(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(r)
+}
+
+// Recorder is a Source that appends every snapshot successfully polled
+// from another Source to a recording, which can be served back by Replay.
+// Failing to write the recording is logged, but does not fail the poll.
+//
+// This code is thread-safe.
+type Recorder struct {
+	src Source
+	log *recordLog
+}
+
+// NewRecorder returns a Recorder that polls src and writes the records
+// to w.  The Recorder does not close w.
+func NewRecorder(src Source, w io.Writer) *Recorder {
+	return &Recorder{src: src, log: &recordLog{enc: json.NewEncoder(w)}}
+}
+
+// RecordingOpener returns an Opener that wraps every Source returned by
+// open in a Recorder writing to w, so that recordings survive reconnections.
+func RecordingOpener(open Opener, w io.Writer) Opener {
+	l := &recordLog{enc: json.NewEncoder(w)}
+	return func() (Source, error) {
+		src, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &Recorder{src: src, log: l}, nil
+	}
+}
+
+// PollSnapshot polls the underlying Source and records the snapshot.
+func (r *Recorder) PollSnapshot() (Snapshot, error) {
+	return r.PollContext(context.Background())
+}
+
+// PollContext is like PollSnapshot, but gives up once ctx is done.
+func (r *Recorder) PollContext(ctx context.Context) (Snapshot, error) {
+	s, err := PollContext(ctx, r.src)
+	if err != nil {
+		return s, err
+	}
+	if err := r.log.write(Record{Time: time.Now(), Snapshot: s}); err != nil {
+		log.Printf("Error recording snapshot: %s", err)
+	}
+	return s, nil
+}
+
+// Close closes the underlying Source.
+func (r *Recorder) Close() {
+	r.src.Close()
+}
+
+// busy returns true while the underlying Source is busy.
+func (r *Recorder) busy() bool {
+	return isBusy(r.src)
+}
+
+// ReadRecords reads a recording made by a Recorder.
+func ReadRecords(rd io.Reader) ([]Record, error) {
+	var records []Record
+	dec := json.NewDecoder(rd)
+	for {
+		var r Record
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+}
+
+// Replay is a Source that serves back a recording.
+//
+// At a positive speed, the recording is played back in real time
+// multiplied by speed, starting with the first poll, and every poll
+// returns the latest record at that point of the recording.  At a speed
+// of zero or less, every poll returns the next record.  Once the
+// recording is exhausted, the last record is returned indefinitely.
+//
+// After Close() has been called, the Replay behaves like a disconnected
+// XenStats and only returns ErrDisconnected.
+//
+// This code is thread-safe.
+type Replay struct {
+	records []Record
+	speed   float64
+	start   time.Time
+	pos     int
+	closed  bool
+	mu      sync.Mutex
+}
+
+// NewReplay returns a Replay of records at the given speed.
+func NewReplay(records []Record, speed float64) *Replay {
+	return &Replay{records: records, speed: speed}
+}
+
+// OpenReplay returns an Opener of Replay that reads the recording at path.
+func OpenReplay(path string, speed float64) Opener {
+	return func() (Source, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		records, err := ReadRecords(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return NewReplay(records, speed), nil
+	}
+}
+
+// PollSnapshot returns a copy of the current record of the recording.
+func (r *Replay) PollSnapshot() (Snapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return Snapshot{}, ErrDisconnected
+	}
+	if len(r.records) == 0 {
+		return Snapshot{Domains: []DomainInfo{}}, nil
+	}
+
+	if r.speed <= 0 {
+		rec := r.records[r.pos]
+		if r.pos < len(r.records)-1 {
+			r.pos++
+		}
+		return copySnapshot(rec.Snapshot), nil
+	}
+
+	now := time.Now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	elapsed := time.Duration(float64(now.Sub(r.start)) * r.speed)
+	at := r.records[0].Time.Add(elapsed)
+	for r.pos < len(r.records)-1 && !r.records[r.pos+1].Time.After(at) {
+		r.pos++
+	}
+	return copySnapshot(r.records[r.pos].Snapshot), nil
+}
+
+// Close marks the Replay as disconnected.
+func (r *Replay) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+var _ ContextSource = (*Recorder)(nil)
+var _ Source = (*Replay)(nil)
+var _ busySource = (*Recorder)(nil)
@@ -0,0 +1,227 @@
+package xenstat
+
+import (
+	"sync"
+	"time"
+)
+
+// VCPURates represents the utilisation of a virtual CPU over an interval.
+type VCPURates struct {
+	// CPUPercent is the percentage of one physical CPU used by this virtual CPU.
+	CPUPercent float64
+}
+
+// VBDRates represents the throughput of a virtual block device over an interval.
+type VBDRates struct {
+	// Dev is the virtual device number of this device.
+	Dev uint32
+	// Device is the name the domain knows this device by.
+	Device string
+	// OutOfRequestsPerSecond is the rate of out-of-request events.
+	OutOfRequestsPerSecond float64
+	// ReadRequestsPerSecond is the rate of read requests.
+	ReadRequestsPerSecond float64
+	// WriteRequestsPerSecond is the rate of write requests.
+	WriteRequestsPerSecond float64
+	// BytesReadPerSecond is the rate of bytes read.
+	BytesReadPerSecond float64
+	// BytesWrittenPerSecond is the rate of bytes written.
+	BytesWrittenPerSecond float64
+}
+
+// NICRates represents the throughput of a virtual NIC over an interval.
+type NICRates struct {
+	// ID is the device number of this virtual NIC within the domain.
+	ID uint32
+	// BytesTransmittedPerSecond is the rate of bytes sent.
+	BytesTransmittedPerSecond float64
+	// BytesReceivedPerSecond is the rate of bytes received.
+	BytesReceivedPerSecond float64
+	// PacketsTransmittedPerSecond is the rate of packets sent.
+	PacketsTransmittedPerSecond float64
+	// PacketsReceivedPerSecond is the rate of packets received.
+	PacketsReceivedPerSecond float64
+}
+
+// DomainRates represents the utilisation of a domain over an interval,
+// computed the same way xentop computes its percentage and throughput
+// columns.
+type DomainRates struct {
+	// Domain is the latest DomainInfo the rates were computed from.
+	Domain DomainInfo
+	// New is true if the domain did not exist at the start of the interval.
+	// All rates of a new domain are zero.
+	New bool
+	// CPUPercent is the percentage of one physical CPU used by the domain,
+	// so a domain saturating two CPUs is at 200%.
+	CPUPercent float64
+	// VCPUs contains the utilisation of each virtual CPU.
+	VCPUs []VCPURates
+	// VBDs contains the throughput of each virtual block device.
+	VBDs []VBDRates
+	// NICs contains the throughput of each virtual NIC.
+	NICs []NICRates
+}
+
+// Rates represents the result of comparing two snapshots.
+type Rates struct {
+	// Interval is the time elapsed between the two snapshots.
+	Interval time.Duration
+	// Domains contains the rates of every domain in the latest snapshot.
+	Domains []DomainRates
+	// Removed contains the domains that disappeared during the interval.
+	Removed []DomainInfo
+}
+
+// counterDelta returns how much a counter has increased, assuming it
+// restarted from zero if it went backwards.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// secondsDelta is the counterDelta of counters expressed in seconds.
+func secondsDelta(prev, cur float64) float64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// ComputeRates computes the rates of every domain in cur relative to prev,
+// which was taken interval earlier.  Domains are matched by domain ID and
+// name, so a domain that was destroyed and recreated under the same name
+// shows up as removed and new, and domains of backends that do not report
+// domain IDs are still told apart.  Devices are matched by their identifiers.
+func ComputeRates(prev, cur Snapshot, interval time.Duration) Rates {
+	r := Rates{Interval: interval}
+	secs := interval.Seconds()
+	rate := func(delta float64) float64 {
+		if secs <= 0 {
+			return 0
+		}
+		return delta / secs
+	}
+
+	prevDomains := make(map[domainKey]DomainInfo)
+	for _, d := range prev.Domains {
+		prevDomains[keyOf(d)] = d
+	}
+	seen := make(map[domainKey]bool)
+
+	for _, d := range cur.Domains {
+		seen[keyOf(d)] = true
+		p, ok := prevDomains[keyOf(d)]
+		if !ok {
+			p = d
+		}
+		dr := DomainRates{
+			Domain:     d,
+			New:        !ok,
+			CPUPercent: rate(secondsDelta(p.CPUSeconds, d.CPUSeconds)) * 100,
+		}
+
+		for n, v := range d.VCPUs {
+			pv := v
+			if n < len(p.VCPUs) {
+				pv = p.VCPUs[n]
+			}
+			dr.VCPUs = append(dr.VCPUs, VCPURates{
+				CPUPercent: rate(secondsDelta(pv.Seconds, v.Seconds)) * 100,
+			})
+		}
+
+		prevVBDs := make(map[uint32]VBDInfo)
+		for _, v := range p.VBDs {
+			prevVBDs[v.Dev] = v
+		}
+		for _, v := range d.VBDs {
+			pv, ok := prevVBDs[v.Dev]
+			if !ok {
+				pv = v
+			}
+			dr.VBDs = append(dr.VBDs, VBDRates{
+				Dev:                    v.Dev,
+				Device:                 v.Device,
+				OutOfRequestsPerSecond: rate(float64(counterDelta(pv.OutOfRequests, v.OutOfRequests))),
+				ReadRequestsPerSecond:  rate(float64(counterDelta(pv.ReadRequests, v.ReadRequests))),
+				WriteRequestsPerSecond: rate(float64(counterDelta(pv.WriteRequests, v.WriteRequests))),
+				BytesReadPerSecond:     rate(float64(counterDelta(pv.BytesRead, v.BytesRead))),
+				BytesWrittenPerSecond:  rate(float64(counterDelta(pv.BytesWritten, v.BytesWritten))),
+			})
+		}
+
+		prevNICs := make(map[uint32]NICInfo)
+		for _, v := range p.NICs {
+			prevNICs[v.ID] = v
+		}
+		for _, v := range d.NICs {
+			pv, ok := prevNICs[v.ID]
+			if !ok {
+				pv = v
+			}
+			dr.NICs = append(dr.NICs, NICRates{
+				ID:                          v.ID,
+				BytesTransmittedPerSecond:   rate(float64(counterDelta(pv.BytesTransmitted, v.BytesTransmitted))),
+				BytesReceivedPerSecond:      rate(float64(counterDelta(pv.BytesReceived, v.BytesReceived))),
+				PacketsTransmittedPerSecond: rate(float64(counterDelta(pv.PacketsTransmitted, v.PacketsTransmitted))),
+				PacketsReceivedPerSecond:    rate(float64(counterDelta(pv.PacketsReceived, v.PacketsReceived))),
+			})
+		}
+
+		r.Domains = append(r.Domains, dr)
+	}
+
+	for _, d := range prev.Domains {
+		if !seen[keyOf(d)] {
+			r.Removed = append(r.Removed, d)
+		}
+	}
+
+	return r
+}
+
+// Sampler polls a Source and computes the rates between consecutive polls,
+// using the real time elapsed between them.
+//
+// This code is thread-safe.
+type Sampler struct {
+	src      Source
+	prev     Snapshot
+	prevTime time.Time
+	mu       sync.Mutex
+}
+
+// NewSampler returns a Sampler that polls src.
+func NewSampler(src Source) *Sampler {
+	return &Sampler{src: src}
+}
+
+// Sample polls the Source and returns the rates since the previous call.
+// On the first call, every domain is reported as new with zero rates.
+//
+// Errors from the Source are returned as is, and the previous snapshot is
+// kept so that the next successful call computes rates over the whole
+// interval.
+func (s *Sampler) Sample() (Rates, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.src.PollSnapshot()
+	if err != nil {
+		return Rates{}, err
+	}
+	now := time.Now()
+
+	var r Rates
+	if s.prevTime.IsZero() {
+		r = ComputeRates(Snapshot{}, cur, 0)
+	} else {
+		r = ComputeRates(s.prev, cur, now.Sub(s.prevTime))
+	}
+	s.prev = cur
+	s.prevTime = now
+	return r, nil
+}
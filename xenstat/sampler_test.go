@@ -0,0 +1,32 @@
+package xenstat
+
+import (
+	"testing"
+	"time"
+)
+
+// Backends such as xentop report every domain with DomainID 0, so domains
+// must be told apart by name too.
+func TestComputeRatesWithoutDomainIDs(t *testing.T) {
+	prev := Snapshot{Domains: []DomainInfo{
+		{Name: "Domain-0", CPUSeconds: 900},
+		{Name: "web1", CPUSeconds: 50},
+	}}
+	cur := Snapshot{Domains: []DomainInfo{
+		{Name: "Domain-0", CPUSeconds: 910},
+		{Name: "web1", CPUSeconds: 55},
+	}}
+	r := ComputeRates(prev, cur, 10*time.Second)
+	if len(r.Removed) != 0 {
+		t.Errorf("got removed domains %+v", r.Removed)
+	}
+	want := map[string]float64{"Domain-0": 100, "web1": 50}
+	for _, d := range r.Domains {
+		if d.New {
+			t.Errorf("%s: reported as new", d.Domain.Name)
+		}
+		if d.CPUPercent != want[d.Domain.Name] {
+			t.Errorf("%s: got %v%% CPU, want %v%%", d.Domain.Name, d.CPUPercent, want[d.Domain.Name])
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package xenstat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shutdownReasons are the names of the shutdown codes of the hypervisor,
+// indexed by code.
+var shutdownReasons = []string{
+	"poweroff",
+	"reboot",
+	"suspend",
+	"crash",
+	"watchdog",
+	"soft_reset",
+}
+
+// shutdownReasonName returns the name of a hypervisor shutdown code.
+func shutdownReasonName(code uint32) string {
+	if int(code) < len(shutdownReasons) {
+		return shutdownReasons[code]
+	}
+	return fmt.Sprintf("unknown_%d", code)
+}
+
+// ReadShutdownReason returns the shutdown last requested to domain domid
+// through xenstore, such as "poweroff" or "reboot", or an empty string if
+// none is pending.  read returns the value at a xenstore path, and false
+// if it cannot be read.
+//
+// Guests clear the request once they act on it, and crashes and watchdog
+// expiries are never requested this way, so the hypervisor should be
+// asked first when possible.
+func ReadShutdownReason(domid uint32, read func(path string) (string, bool)) string {
+	v, ok := read(fmt.Sprintf("/local/domain/%d/control/shutdown", domid))
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,83 @@
+package xenstat
+
+import (
+	"context"
+)
+
+// Source is implemented by anything that can produce snapshots of the
+// running Xen domains.  XenStats is the canonical implementation; FakeSource
+// is provided for testing consumers of this package without a Xen host.
+type Source interface {
+	// PollSnapshot returns the host information and the list of
+	// DomainInfo.  If ErrDisconnected is returned, the Source can no
+	// longer be used and must be replaced.
+	PollSnapshot() (Snapshot, error)
+	// Close releases resources associated with the Source.
+	Close()
+}
+
+// ContextSource is implemented by Sources that can abandon a poll once
+// a context is done.
+type ContextSource interface {
+	Source
+	// PollContext is like PollSnapshot, but returns the error of the
+	// context as soon as ctx is done.
+	PollContext(ctx context.Context) (Snapshot, error)
+}
+
+// busySource is implemented by Sources whose abandoned calls can keep
+// running after Close, such as XenStats after a poll timed out.
+type busySource interface {
+	// busy returns true until the Source has finished all of its calls.
+	busy() bool
+}
+
+// isBusy returns true if src is a busySource that is still busy.
+func isBusy(src Source) bool {
+	b, ok := src.(busySource)
+	return ok && b.busy()
+}
+
+// copySnapshot returns a deep copy of s, for Sources that hand out the
+// same snapshot more than once, since consumers such as Enrich modify the
+// domains in place.
+func copySnapshot(s Snapshot) Snapshot {
+	if s.PCPUs != nil {
+		s.PCPUs = append(make([]PCPUInfo, 0, len(s.PCPUs)), s.PCPUs...)
+	}
+	if s.Domains == nil {
+		return s
+	}
+	domains := make([]DomainInfo, len(s.Domains))
+	for i, d := range s.Domains {
+		if d.VBDs != nil {
+			d.VBDs = append(make([]VBDInfo, 0, len(d.VBDs)), d.VBDs...)
+		}
+		if d.NICs != nil {
+			d.NICs = append(make([]NICInfo, 0, len(d.NICs)), d.NICs...)
+		}
+		if d.VCPUs != nil {
+			d.VCPUs = append(make([]VCPUInfo, 0, len(d.VCPUs)), d.VCPUs...)
+		}
+		domains[i] = d
+	}
+	s.Domains = domains
+	return s
+}
+
+// PollContext polls src with ctx if src is a ContextSource.  Otherwise,
+// ctx is only checked before polling, and the poll runs to completion.
+func PollContext(ctx context.Context, src Source) (Snapshot, error) {
+	if c, ok := src.(ContextSource); ok {
+		return c.PollContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	return src.PollSnapshot()
+}
+
+// Opener returns a new, connected Source.
+type Opener func() (Source, error)
+
+var _ ContextSource = (*XenStats)(nil)
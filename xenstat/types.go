@@ -0,0 +1,30 @@
+package xenstat
+
+import "github.com/Rudd-O/prometheus-xentop/xenstat/xeninfo"
+
+// The data types live in the xeninfo package, which does not need cgo.
+// They are aliased here so that existing users of this package keep
+// working unchanged.
+type (
+	DomainState   = xeninfo.DomainState
+	VBDInfo       = xeninfo.VBDInfo
+	VCPUInfo      = xeninfo.VCPUInfo
+	NICInfo       = xeninfo.NICInfo
+	DomainInfo    = xeninfo.DomainInfo
+	MemoryDetails = xeninfo.MemoryDetails
+	NodeInfo      = xeninfo.NodeInfo
+	PCPUInfo      = xeninfo.PCPUInfo
+	Snapshot      = xeninfo.Snapshot
+)
+
+const (
+	Dying    = xeninfo.Dying
+	Shutdown = xeninfo.Shutdown
+	Blocked  = xeninfo.Blocked
+	Crashed  = xeninfo.Crashed
+	Paused   = xeninfo.Paused
+	Running  = xeninfo.Running
+)
+
+// DomainStates lists every DomainState flag, in the order xentop shows them.
+var DomainStates = xeninfo.DomainStates
@@ -0,0 +1,76 @@
+package xenstat
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Vdev is a decoded Xen virtual block device number, as described in
+// docs/misc/vbd-interface.txt of the Xen source tree.
+type Vdev struct {
+	// Prefix is the device name prefix the guest uses: "xvd", "sd" or "hd".
+	Prefix string
+	// Disk is the disk number, starting at 0 for the "a" disk.
+	Disk uint32
+	// Partition is the partition number, or 0 for the whole disk.
+	Partition uint32
+}
+
+const (
+	vdevExtended = 1 << 28
+	vdevReserved = 2 << 28
+)
+
+// DecodeVdev decodes a virtual block device number.  It supports the
+// extended xvd encoding as well as the xvd, SCSI and IDE encodings, and
+// returns an error for reserved or deprecated numbers.
+func DecodeVdev(dev uint32) (Vdev, error) {
+	if dev >= vdevReserved {
+		return Vdev{}, fmt.Errorf("reserved virtual device number %d", dev)
+	}
+	if dev&vdevExtended != 0 {
+		return Vdev{"xvd", (dev >> 8) & (1<<20 - 1), dev & 255}, nil
+	}
+	major, minor := dev>>8, dev&255
+	switch major {
+	case 202:
+		return Vdev{"xvd", minor >> 4, minor & 15}, nil
+	case 8:
+		return Vdev{"sd", minor >> 4, minor & 15}, nil
+	case 3:
+		return Vdev{"hd", minor >> 6, minor & 63}, nil
+	case 22:
+		return Vdev{"hd", 2 + minor>>6, minor & 63}, nil
+	}
+	return Vdev{}, fmt.Errorf("unsupported virtual device number %d", dev)
+}
+
+// diskLetters returns the letters of a disk number: a to z, then aa,
+// ab and so on.
+func diskLetters(disk uint32) string {
+	var letters []byte
+	for n := uint64(disk) + 1; n > 0; n = (n - 1) / 26 {
+		letters = append([]byte{byte('a' + (n-1)%26)}, letters...)
+	}
+	return string(letters)
+}
+
+// String returns the device name the guest knows the device by, such as
+// xvda, xvdaa3 or hdb.
+func (v Vdev) String() string {
+	name := v.Prefix + diskLetters(v.Disk)
+	if v.Partition > 0 {
+		name += strconv.FormatUint(uint64(v.Partition), 10)
+	}
+	return name
+}
+
+// vdevName returns the guest device name of dev, or the number itself if
+// it cannot be decoded.
+func vdevName(dev uint32) string {
+	v, err := DecodeVdev(dev)
+	if err != nil {
+		return strconv.FormatUint(uint64(dev), 10)
+	}
+	return v.String()
+}
@@ -0,0 +1,50 @@
+package xenstat
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeVdev(t *testing.T) {
+	tests := []struct {
+		dev  uint32
+		want Vdev
+		name string
+	}{
+		{202<<8 | 0, Vdev{"xvd", 0, 0}, "xvda"},
+		{202<<8 | 17, Vdev{"xvd", 1, 1}, "xvdb1"},
+		{202<<8 | 15<<4 | 15, Vdev{"xvd", 15, 15}, "xvdp15"},
+		{8<<8 | 35, Vdev{"sd", 2, 3}, "sdc3"},
+		{3<<8 | 0, Vdev{"hd", 0, 0}, "hda"},
+		{3<<8 | 65, Vdev{"hd", 1, 1}, "hdb1"},
+		{22<<8 | 0, Vdev{"hd", 2, 0}, "hdc"},
+		{22<<8 | 64 | 5, Vdev{"hd", 3, 5}, "hdd5"},
+		{1<<28 | 0, Vdev{"xvd", 0, 0}, "xvda"},
+		{1<<28 | 26<<8 | 3, Vdev{"xvd", 26, 3}, "xvdaa3"},
+		{1<<28 | 702<<8, Vdev{"xvd", 702, 0}, "xvdaaa"},
+	}
+	for _, tt := range tests {
+		got, err := DecodeVdev(tt.dev)
+		if err != nil {
+			t.Errorf("DecodeVdev(%d): %s", tt.dev, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("DecodeVdev(%d) = %+v, want %+v", tt.dev, got, tt.want)
+		}
+		if got.String() != tt.name {
+			t.Errorf("DecodeVdev(%d).String() = %q, want %q", tt.dev, got.String(), tt.name)
+		}
+	}
+}
+
+func TestDecodeVdevInvalid(t *testing.T) {
+	for _, dev := range []uint32{2 << 28, 3<<28 | 202<<8, 1<<32 - 1, 7 << 8, 0} {
+		if v, err := DecodeVdev(dev); err == nil {
+			t.Errorf("DecodeVdev(%d) = %+v, want an error", dev, v)
+		}
+		if got, want := vdevName(dev), fmt.Sprint(dev); got != want {
+			t.Errorf("vdevName(%d) = %q, want %q", dev, got, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package xeninfo contains the data types produced by the xenstat package.
+// It does not depend on cgo, so programs that only consume the data, for
+// instance from recorded JSON snapshots, can be built anywhere.
+package xeninfo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DomainState is a set of flags describing the state of a domain.  More than
+// one flag may be set at the same time, e.g. a domain can be both paused
+// and shutting down.
+type DomainState uint8
+
+const (
+	Dying DomainState = 1 << iota
+	Shutdown
+	Blocked
+	Crashed
+	Paused
+	Running
+)
+
+// DomainStates lists every DomainState flag, in the order xentop shows them.
+var DomainStates = []DomainState{Dying, Shutdown, Blocked, Crashed, Paused, Running}
+
+var domainStateNames = map[DomainState]string{
+	Dying:    "dying",
+	Shutdown: "shutdown",
+	Blocked:  "blocked",
+	Crashed:  "crashed",
+	Paused:   "paused",
+	Running:  "running",
+}
+
+// Has returns true if all the flags in f are set in s.
+func (s DomainState) Has(f DomainState) bool {
+	return s&f == f
+}
+
+// String returns the comma-separated names of the flags set in s,
+// or "none" if no flag is set.
+func (s DomainState) String() string {
+	names := []string{}
+	for _, f := range DomainStates {
+		if s.Has(f) {
+			names = append(names, domainStateNames[f])
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// MarshalText encodes s the same way as String.
+func (s DomainState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes the output of MarshalText.
+func (s *DomainState) UnmarshalText(b []byte) error {
+	text := string(b)
+	var state DomainState
+	if text != "none" && text != "" {
+	next:
+		for _, name := range strings.Split(text, ",") {
+			for f, n := range domainStateNames {
+				if n == name {
+					state |= f
+					continue next
+				}
+			}
+			return fmt.Errorf("unknown domain state %q", name)
+		}
+	}
+	*s = state
+	return nil
+}
+
+type VBDInfo struct {
+	// Dev is the virtual device number of this device, as seen by the domain.
+	Dev uint32
+	// Device is the name the domain knows this device by, such as xvda,
+	// or the decimal device number if it could not be decoded.
+	Device string
+	// Major is the major block device number.  It is truncated for
+	// devices using the extended encoding; use Dev or Device instead.
+	Major uint8
+	// Minor is the minor block device number.  It is truncated for
+	// devices using the extended encoding; use Dev or Device instead.
+	Minor uint8
+	// OutOfRequests is the count of out-of-request events for this device.
+	OutOfRequests uint64
+	// ReadRequests is the count of read requests for this device.
+	ReadRequests uint64
+	// ReadRequests is the count of write requests for this device.
+	WriteRequests uint64
+	// BytesRead is the total number of bytes read by this device.
+	BytesRead uint64
+	// BytesWritten is the total number of bytes read by this device.
+	BytesWritten uint64
+	// FlushRequests is the count of flush requests for this device.
+	// Only available from blkback statistics in sysfs.
+	FlushRequests uint64
+	// DiscardRequests is the count of discard requests for this device.
+	// Only available from blkback statistics in sysfs.
+	DiscardRequests uint64
+	// Mode is "r" for read-only devices and "w" for writable ones.
+	// Only available from blkback statistics in sysfs.
+	Mode string
+	// PhysicalDevice is the major:minor number, in hexadecimal, of the
+	// dom0 device backing this device.  Only available from blkback
+	// statistics in sysfs.
+	PhysicalDevice string
+}
+
+type VCPUInfo struct {
+	// Online is true when the virtual CPU is online.
+	Online bool
+	// Seconds is the total amount of CPU-seconds taken by execution of this virtual CPU.
+	Seconds float64
+}
+
+type NICInfo struct {
+	// ID is the device number of this virtual NIC within the domain.
+	ID uint32
+	// BytesTransmitted is the total number of bytes sent by this virtual NIC.
+	BytesTransmitted uint64
+	// BytesTransmitted is the total number of bytes received by this virtual NIC.
+	BytesReceived uint64
+	// PacketsTransmitted is the total number of packets sent by this virtual NIC.
+	PacketsTransmitted uint64
+	// PacketsReceived is the total number of packets received by this virtual NIC.
+	PacketsReceived uint64
+	// ErrorsTransmitted is the count of transmit errors of this virtual NIC.
+	ErrorsTransmitted uint64
+	// ErrorsReceived is the count of receive errors of this virtual NIC.
+	ErrorsReceived uint64
+	// DropsTransmitted is the count of outgoing packets dropped by this virtual NIC.
+	DropsTransmitted uint64
+	// DropsReceived is the count of incoming packets dropped by this virtual NIC.
+	DropsReceived uint64
+}
+
+// DomainInfo represents a snapshot of numeric information about a Xen domain.
+type DomainInfo struct {
+	// Name is the domain name.
+	Name string
+	// DomainID is the numeric domain ID, which changes every time the domain is created.
+	DomainID uint32
+	// UUID is the UUID of the virtual machine, which persists across domain restarts.
+	// It is empty if it could not be determined.
+	UUID string
+	// State represents in which state the domain is.
+	State DomainState
+	// ShutdownReason is why a domain in the Shutdown or Crashed state shut
+	// down, such as "poweroff", "reboot", "suspend", "crash", "watchdog" or
+	// "soft_reset".  It is empty if the domain is not shut down or the
+	// reason is not known.
+	ShutdownReason string
+	// CPUSeconds is the total amount of CPU-seconds taken by execution of the domain since it started.
+	CPUSeconds float64
+	// NumVCPUs is the number of CPUs assigned to the domain.
+	NumVCPUs uint32
+	// MemoryBytes is the current consumption of memory of the domain.
+	MemoryBytes uint64
+	// MaxMemBytes is the maximum allocatable memory for the domain.
+	MaxmemBytes uint64
+	// NumVBDs is the number of virtual block devices assigned to the domain.
+	NumVBDs uint32
+	// NumNICs is the number of Xen-virtual network interfaces assigned to the domain.
+	NumNICs uint32
+	// VBDs contains a list of VBDInfo that disaggregates the statistics for each virtual block device.
+	VBDs []VBDInfo
+	// NICs contains a list of NICInfo that disaggregates the statistics for each virtual network device.
+	NICs []NICInfo
+	// VCPUs contains a list of VCPUInfo that disaggregates the statistics for each virtual CPU.
+	VCPUs []VCPUInfo
+	// Memory contains the memory settings of the domain kept in xenstore.
+	Memory MemoryDetails
+}
+
+// MemoryDetails represents the memory settings of a domain kept in
+// xenstore, and the memory usage the guest itself reports there.  Values
+// that are not available are zero.
+type MemoryDetails struct {
+	// TargetBytes is the current balloon target of the domain.
+	TargetBytes uint64
+	// StaticMaxBytes is the most memory the domain can be ballooned up to.
+	StaticMaxBytes uint64
+	// VideoRAMBytes is the memory set aside for the emulated video card.
+	VideoRAMBytes uint64
+	// GuestUsedBytes is the memory the guest reports as used.  Only
+	// available on guests that report it, such as those of Qubes OS.
+	GuestUsedBytes uint64
+	// GuestFreeBytes is the memory the guest reports as free.
+	GuestFreeBytes uint64
+	// GuestCachedBytes is the memory the guest reports as used for caches.
+	GuestCachedBytes uint64
+}
+
+// NodeInfo represents a snapshot of numeric information about the Xen host.
+type NodeInfo struct {
+	// TotalMemoryBytes is the total amount of physical memory on the host.
+	TotalMemoryBytes uint64
+	// FreeMemoryBytes is the amount of physical memory not allocated to any domain.
+	FreeMemoryBytes uint64
+	// FreeableMemoryBytes is the amount of memory that could be freed by the hypervisor.
+	FreeableMemoryBytes uint64
+	// NumCPUs is the number of physical CPUs on the host.
+	NumCPUs uint32
+	// CPUHz is the clock frequency of the physical CPUs.
+	CPUHz uint64
+	// XenVersion is the version string of the running hypervisor.
+	XenVersion string
+}
+
+// PCPUInfo represents a snapshot of numeric information about a physical CPU.
+type PCPUInfo struct {
+	// Online is true when the CPU is online.
+	Online bool
+	// BusySeconds is the total amount of seconds this CPU has spent executing domains.
+	BusySeconds float64
+}
+
+// Snapshot represents the result of a single poll of the Xen host.
+type Snapshot struct {
+	// Node contains the host-level information.
+	Node NodeInfo
+	// PCPUs contains a list of PCPUInfo, indexed by physical CPU number.
+	PCPUs []PCPUInfo
+	// Domains contains the information of each running domain.
+	Domains []DomainInfo
+}
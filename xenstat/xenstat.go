@@ -1,80 +1,24 @@
+//go:build cgo && libxenstat
+// +build cgo,libxenstat
+
 package xenstat
 
-// #cgo pkg-config: xenstat
+// #cgo pkg-config: xenstat xenstore xencontrol
+// #include <stdlib.h>
 // #include <xenstat.h>
+// #include <xenstore.h>
+// #include <xenctrl.h>
 import "C"
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
+	"path"
+	"runtime"
 	"sync"
+	"unsafe"
 )
 
-// ErrDisconnected happens when xend disconnects.
-var ErrDisconnected = errors.New("not connected to xend")
-
-// ErrCannotConnect happens when xend is not available.
-var ErrCannotConnect = errors.New("cannot connect to xend")
-
-type DomainState string
-
-const (
-	Dying    DomainState = "dying"
-	Shutdown DomainState = "shutdown"
-	Blocked  DomainState = "blocked"
-	Crashed  DomainState = "crashed"
-	Paused   DomainState = "paused"
-	Running  DomainState = "running"
-)
-
-type VBDInfo struct {
-	// Major is the major block device number.
-	Major uint8
-	// Minor is the minor block device number.
-	Minor uint8
-	// OutOfRequests is the count of out-of-request events for this device.
-	OutOfRequests uint64
-	// ReadRequests is the count of read requests for this device.
-	ReadRequests uint64
-	// ReadRequests is the count of write requests for this device.
-	WriteRequests uint64
-	// BytesRead is the total number of bytes read by this device.
-	BytesRead uint64
-	// BytesWritten is the total number of bytes read by this device.
-	BytesWritten uint64
-}
-
-type NICInfo struct {
-	// BytesTransmitted is the total number of bytes sent by this virtual NIC.
-	BytesTransmitted uint64
-	// BytesTransmitted is the total number of bytes received by this virtual NIC.
-	BytesReceived uint64
-}
-
-// DomainInfo represents a snapshot of numeric information about a Xen domain.
-type DomainInfo struct {
-	// Name is the domain name.
-	Name string
-	// State represents in which state the domain is.
-	State DomainState
-	// CPUSeconds is the total amount of CPU-seconds taken by execution of the domain since it started.
-	CPUSeconds float64
-	// NumVCPUs is the number of CPUs assigned to the domain.
-	NumVCPUs uint32
-	// MemoryBytes is the current consumption of memory of the domain.
-	MemoryBytes uint64
-	// MaxMemBytes is the maximum allocatable memory for the domain.
-	MaxmemBytes uint64
-	// NumVBDs is the number of virtual block devices assigned to the domain.
-	NumVBDs uint32
-	// NumNICs is the number of Xen-virtual network interfaces assigned to the domain.
-	NumNICs uint32
-	// VBDs contains a list of VBDInfo that disaggregates the statistics for each virtual block device.
-	VBDs []VBDInfo
-	// NICs contains a list of NICInfo that disaggregates the statistics for each virtual network device.
-	NICs []NICInfo
-}
-
 type vbdT int
 
 const (
@@ -90,20 +34,50 @@ type netT int
 const (
 	f_NET_TX netT = iota
 	f_NET_RX
+	f_NET_TXPKT
+	f_NET_RXPKT
+	f_NET_TXERR
+	f_NET_RXERR
+	f_NET_TXDROP
+	f_NET_RXDROP
 )
 
-func dev_net_bytes(domain *C.xenstat_domain, t netT, devid uint32) uint64 {
+func dev_net_stat(domain *C.xenstat_domain, t netT, devid uint32) (uint64, error) {
 	var v *C.xenstat_network
 	v = C.xenstat_domain_network(domain, C.uint(devid))
+	if v == nil {
+		return 0, fmt.Errorf("could not get network %d type %v from domain %+v", devid, t, domain)
+	}
 	switch t {
 	case f_NET_RX:
-		return uint64(C.xenstat_network_rbytes(v))
+		return uint64(C.xenstat_network_rbytes(v)), nil
 	case f_NET_TX:
-		return uint64(C.xenstat_network_tbytes(v))
+		return uint64(C.xenstat_network_tbytes(v)), nil
+	case f_NET_RXPKT:
+		return uint64(C.xenstat_network_rpackets(v)), nil
+	case f_NET_TXPKT:
+		return uint64(C.xenstat_network_tpackets(v)), nil
+	case f_NET_RXERR:
+		return uint64(C.xenstat_network_rerrs(v)), nil
+	case f_NET_TXERR:
+		return uint64(C.xenstat_network_terrs(v)), nil
+	case f_NET_RXDROP:
+		return uint64(C.xenstat_network_rdrop(v)), nil
+	case f_NET_TXDROP:
+		return uint64(C.xenstat_network_tdrop(v)), nil
 	}
 	panic("wrong case")
 }
 
+func dev_net_id(domain *C.xenstat_domain, devid uint32) (uint32, error) {
+	var v *C.xenstat_network
+	v = C.xenstat_domain_network(domain, C.uint(devid))
+	if v == nil {
+		return 0, fmt.Errorf("could not get network %d id from domain %+v", devid, domain)
+	}
+	return uint32(C.xenstat_network_id(v)), nil
+}
+
 func dev_vbd_reqs(domain *C.xenstat_domain, t vbdT, devid uint32) (uint64, error) {
 	var v *C.xenstat_vbd
 	v = C.xenstat_domain_vbd(domain, C.uint(devid))
@@ -125,44 +99,214 @@ func dev_vbd_reqs(domain *C.xenstat_domain, t vbdT, devid uint32) (uint64, error
 	panic("wrong case")
 }
 
-func dev_vbd_major_minor(domain *C.xenstat_domain, devid uint32) (uint8, uint8, error) {
+func dev_vbd_dev(domain *C.xenstat_domain, devid uint32) (uint32, error) {
 	var v *C.xenstat_vbd
 	v = C.xenstat_domain_vbd(domain, C.uint(devid))
 	if v == nil {
-		return 0, 0, fmt.Errorf("could not get VBD %d major/minor from domain %+v", devid, domain)
+		return 0, fmt.Errorf("could not get VBD %d device number from domain %+v", devid, domain)
+	}
+	return uint32(C.xenstat_vbd_dev(v)), nil
+}
+
+func xs_read_string(xs *C.struct_xs_handle, p string) (string, bool) {
+	cpath := C.CString(p)
+	defer C.free(unsafe.Pointer(cpath))
+	var length C.uint
+	v := C.xs_read(xs, C.XBT_NULL, cpath, &length)
+	if v == nil {
+		return "", false
 	}
-	var dev C.uint = C.xenstat_vbd_dev(v)
-	var major uint8 = uint8(255 & (dev >> 8))
-	var minor uint8 = uint8(255 & dev)
-	return major, minor, nil
+	defer C.free(v)
+	return C.GoStringN((*C.char)(v), C.int(length)), true
 }
 
+// xc_shutdown_code returns the shutdown code of a domain that is shut
+// down, as recorded by the hypervisor.
+func xc_shutdown_code(xc *C.xc_interface, domid uint32) (uint32, bool) {
+	var info C.xc_domaininfo_t
+	if C.xc_domain_getinfolist(xc, C.uint32_t(domid), 1, &info) != 1 || uint32(info.domain) != domid {
+		return 0, false
+	}
+	if info.flags&C.XEN_DOMINF_shutdown == 0 {
+		return 0, false
+	}
+	return uint32((info.flags >> C.XEN_DOMINF_shutdownshift) & C.XEN_DOMINF_shutdownmask), true
+}
+
+// HaveLibxenstat is true when the libxenstat backend is built in.
+const HaveLibxenstat = true
+
 // XenStats represents a connection to the xend service which permits
 // retrieval of statistics from the running Xen domains.
+//
+// All calls into libxenstat are made from a single worker goroutine locked
+// to its own OS thread, so that a hung call can be abandoned by the caller.
 type XenStats struct {
+	// handle, xs and xc are only ever touched by the worker goroutine.
 	handle *C.xenstat_handle
+	xs     *C.struct_xs_handle
+	xc     *C.xc_interface
+	// calls feeds the worker goroutine.  It is nil once the worker has
+	// been retired.
+	calls chan func()
+	// exited is closed once the worker goroutine has returned.
+	exited chan struct{}
+	opts   options
 	mu     sync.Mutex
 }
 
+// cflags translates the collection flags into libxenstat flags.
+func (f Flags) cflags() C.uint {
+	var c C.uint
+	if f&CollectVCPUs != 0 {
+		c |= C.XENSTAT_VCPU
+	}
+	if f&CollectNetworks != 0 {
+		c |= C.XENSTAT_NETWORK
+	}
+	if f&CollectVBDs != 0 {
+		c |= C.XENSTAT_VBD
+	}
+	if f&CollectXenVersion != 0 {
+		c |= C.XENSTAT_XEN_VERSION
+	}
+	return c
+}
+
+// domainUUID looks up the UUID of the domain in xenstore.
+func (x *XenStats) domainUUID(domid uint32) string {
+	if x.xs == nil {
+		return ""
+	}
+	vm, ok := xs_read_string(x.xs, fmt.Sprintf("/local/domain/%d/vm", domid))
+	if !ok {
+		return ""
+	}
+	return path.Base(vm)
+}
+
+// domainShutdownReason returns why the domain is shut down, preferably
+// as recorded by the hypervisor, or else as requested in xenstore.
+func (x *XenStats) domainShutdownReason(domid uint32) string {
+	if x.xc != nil {
+		if code, ok := xc_shutdown_code(x.xc, domid); ok {
+			return shutdownReasonName(code)
+		}
+	}
+	if x.xs == nil {
+		return ""
+	}
+	return ReadShutdownReason(domid, func(p string) (string, bool) {
+		return xs_read_string(x.xs, p)
+	})
+}
+
+// worker connects to xend and then runs calls until the channel is closed,
+// at which point it releases the connection.  The OS thread is never
+// unlocked, so the runtime discards it when the worker exits.
+func (x *XenStats) worker(calls <-chan func(), ready chan<- error) {
+	defer close(x.exited)
+	runtime.LockOSThread()
+
+	x.handle = C.xenstat_init()
+	if x.handle == nil {
+		ready <- ErrCannotConnect
+		return
+	}
+	x.xs = C.xs_open(C.XS_OPEN_READONLY)
+	if x.xs == nil {
+		log.Printf("cannot connect to xenstore, domain UUIDs will not be available")
+	}
+	x.xc = C.xc_interface_open(nil, nil, 0)
+	if x.xc == nil {
+		log.Printf("cannot open the hypervisor interface, shutdown reasons will be taken from xenstore")
+	}
+	ready <- nil
+
+	for call := range calls {
+		call()
+	}
+
+	if x.handle != nil {
+		C.xenstat_uninit(x.handle)
+		x.handle = nil
+	}
+	if x.xs != nil {
+		C.xs_close(x.xs)
+		x.xs = nil
+	}
+	if x.xc != nil {
+		C.xc_interface_close(x.xc)
+		x.xc = nil
+	}
+}
+
+// retire stops the worker once it is done with its current call, if any.
+// The caller must hold the lock.
+func (x *XenStats) retire() {
+	if x.calls != nil {
+		close(x.calls)
+		x.calls = nil
+	}
+}
+
+// busy returns true until the worker has returned, which a retired
+// worker only does once its current call into libxenstat finishes.
+func (x *XenStats) busy() bool {
+	select {
+	case <-x.exited:
+		return false
+	default:
+		return true
+	}
+}
+
 // NewXenStats connects to the xend service.  If xend is not available,
 // you'll get ErrCannotConnect.
 //
+// By default, all available data is collected on each poll.  Use WithFlags
+// to collect less.
+//
 // Users must call Close() after they are done with the returned XenStats
 // instance.
-func NewXenStats() (*XenStats, error) {
-	handle := C.xenstat_init()
-	if handle == nil {
-		return nil, ErrCannotConnect
+func NewXenStats(opts ...Option) (*XenStats, error) {
+	x := &XenStats{calls: make(chan func()), exited: make(chan struct{}), opts: defaultOptions()}
+	for _, o := range opts {
+		o(&x.opts)
+	}
+	ready := make(chan error)
+	go x.worker(x.calls, ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// OpenXenStats is an Opener that connects to the xend service.
+func OpenXenStats() (Source, error) {
+	return OpenXenStatsWith()()
+}
+
+// OpenXenStatsWith returns an Opener that connects to the xend service
+// with the given options.
+func OpenXenStatsWith(opts ...Option) Opener {
+	return func() (Source, error) {
+		x, err := NewXenStats(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return x, nil
 	}
-	return &XenStats{handle: handle}, nil
 }
 
 // Close() releases resources associated with the XenStats instance.
+// It is safe to call Close() more than once, and after Poll() has
+// returned an error.  If a poll abandoned by PollContext is still running,
+// the resources are released as soon as it finishes.
 func (x *XenStats) Close() {
 	x.mu.Lock()
 	defer x.mu.Unlock()
-	defer C.xenstat_uninit(x.handle)
-	x.handle = nil
+	x.retire()
 }
 
 // Poll returns a list of DomainInfo.
@@ -173,21 +317,110 @@ func (x *XenStats) Close() {
 //
 // This code is thread-safe.
 func (x *XenStats) Poll() ([]DomainInfo, error) {
-	if x.handle == nil {
-		return nil, ErrDisconnected
+	s, err := x.PollSnapshot()
+	if err != nil {
+		return nil, err
 	}
+	return s.Domains, nil
+}
 
+// PollSnapshot returns a Snapshot containing the host information
+// as well as the list of DomainInfo.
+//
+// Errors are handled the same way as in Poll.
+//
+// This code is thread-safe.
+func (x *XenStats) PollSnapshot() (Snapshot, error) {
+	return x.PollContext(context.Background())
+}
+
+// PollContext is like PollSnapshot, but returns as soon as ctx is done,
+// with the error of the context.  Since the abandoned call into libxenstat
+// cannot be interrupted, this instance of XenStats is then retired and
+// further polls return ErrDisconnected, just as if xend had disconnected.
+//
+// This code is thread-safe.
+func (x *XenStats) PollContext(ctx context.Context) (Snapshot, error) {
 	x.mu.Lock()
 	defer x.mu.Unlock()
 
-	cur_node := C.xenstat_get_node(x.handle, C.XENSTAT_ALL)
+	if x.calls == nil {
+		return Snapshot{}, ErrDisconnected
+	}
+
+	type result struct {
+		s   Snapshot
+		err error
+	}
+	done := make(chan result, 1)
+	call := func() {
+		s, err := x.poll()
+		done <- result{s, err}
+	}
+
+	select {
+	case x.calls <- call:
+	case <-ctx.Done():
+		return Snapshot{}, ctx.Err()
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			x.retire()
+		}
+		return r.s, r.err
+	case <-ctx.Done():
+		x.retire()
+		return Snapshot{}, ctx.Err()
+	}
+}
+
+// poll does the actual work of PollContext.  It must only be called by
+// the worker goroutine.
+func (x *XenStats) poll() (Snapshot, error) {
+	if x.handle == nil {
+		return Snapshot{}, ErrDisconnected
+	}
+
+	cur_node := C.xenstat_get_node(x.handle, x.opts.flags.cflags())
 	if cur_node == nil {
 		C.xenstat_uninit(x.handle)
 		x.handle = nil
-		return nil, ErrDisconnected
+		return Snapshot{}, ErrDisconnected
 	}
 	defer C.xenstat_free_node(cur_node)
 
+	nodedata := NodeInfo{
+		TotalMemoryBytes: uint64(C.xenstat_node_tot_mem(cur_node)),
+		FreeMemoryBytes:  uint64(C.xenstat_node_free_mem(cur_node)),
+		NumCPUs:          uint32(C.xenstat_node_num_cpus(cur_node)),
+		CPUHz:            uint64(C.xenstat_node_cpu_hz(cur_node)),
+	}
+	if freeable := int64(C.xenstat_node_freeable_mb(cur_node)); freeable > 0 {
+		nodedata.FreeableMemoryBytes = uint64(freeable) * 1024 * 1024
+	}
+	if x.opts.flags&CollectXenVersion != 0 {
+		if cversion := C.xenstat_node_xen_version(cur_node); cversion != nil {
+			nodedata.XenVersion = C.GoString(cversion)
+		}
+	}
+
+	pcpudata := []PCPUInfo{}
+	var c C.uint
+	for c = 0; c < C.uint(nodedata.NumCPUs); c++ {
+		cpu := C.xenstat_node_cpu(cur_node, c)
+		if cpu == nil {
+			log.Printf("could not get physical CPU %d", c)
+			pcpudata = append(pcpudata, PCPUInfo{})
+			continue
+		}
+		pcpudata = append(pcpudata, PCPUInfo{
+			Online:      C.xenstat_cpu_online(cpu) != 0,
+			BusySeconds: float64(uint64(C.xenstat_cpu_ns(cpu))) / 1000 / 1000 / 1000,
+		})
+	}
+
 	num_domains := C.xenstat_node_num_domains(cur_node)
 
 	domains := []*C.xenstat_domain{}
@@ -197,7 +430,7 @@ func (x *XenStats) Poll() ([]DomainInfo, error) {
 		if d == nil {
 			C.xenstat_uninit(x.handle)
 			x.handle = nil
-			return nil, ErrDisconnected
+			return Snapshot{}, ErrDisconnected
 		}
 		domains = append(domains, d)
 	}
@@ -209,30 +442,41 @@ func (x *XenStats) Poll() ([]DomainInfo, error) {
 		name := C.GoString(cname)
 		var state DomainState
 		if C.xenstat_domain_dying(domain) != 0 {
-			state = Dying
+			state |= Dying
 		}
 		if C.xenstat_domain_shutdown(domain) != 0 {
-			state = Shutdown
+			state |= Shutdown
 		}
 		if C.xenstat_domain_blocked(domain) != 0 {
-			state = Blocked
+			state |= Blocked
 		}
 		if C.xenstat_domain_crashed(domain) != 0 {
-			state = Crashed
+			state |= Crashed
 		}
 		if C.xenstat_domain_paused(domain) != 0 {
-			state = Paused
+			state |= Paused
 		}
 		if C.xenstat_domain_running(domain) != 0 {
-			state = Running
+			state |= Running
 		}
 
-		num_vbds := uint32(C.xenstat_domain_num_vbds(domain))
-		num_nics := uint32(C.xenstat_domain_num_networks(domain))
+		// libxenstat does not allocate the per-device arrays of the
+		// data it was not asked to collect, so those must not be walked.
+		var num_vbds, num_nics, num_vcpus uint32
+		if x.opts.flags&CollectVBDs != 0 {
+			num_vbds = uint32(C.xenstat_domain_num_vbds(domain))
+		}
+		if x.opts.flags&CollectNetworks != 0 {
+			num_nics = uint32(C.xenstat_domain_num_networks(domain))
+		}
+		if x.opts.flags&CollectVCPUs != 0 {
+			num_vcpus = uint32(C.xenstat_domain_num_vcpus(domain))
+		}
 
 		var i uint32
 		var vv []VBDInfo
 		var nn []NICInfo
+		var cc []VCPUInfo
 		for i = 0; i < num_vbds; i++ {
 			outOfRequests, err := dev_vbd_reqs(domain, f_VBD_OO, i)
 			if err != nil {
@@ -259,14 +503,16 @@ func (x *XenStats) Poll() ([]DomainInfo, error) {
 				log.Printf("%s: %s", name, err)
 				continue
 			}
-			major, minor, err := dev_vbd_major_minor(domain, i)
+			dev, err := dev_vbd_dev(domain, i)
 			if err != nil {
 				log.Printf("%s: %s", name, err)
 				continue
 			}
 			vbdinfo := VBDInfo{
-				Major:         major,
-				Minor:         minor,
+				Dev:           dev,
+				Device:        vdevName(dev),
+				Major:         uint8(255 & (dev >> 8)),
+				Minor:         uint8(255 & dev),
 				OutOfRequests: outOfRequests,
 				ReadRequests:  readRequests,
 				WriteRequests: writeRequests,
@@ -276,26 +522,112 @@ func (x *XenStats) Poll() ([]DomainInfo, error) {
 			vv = append(vv, vbdinfo)
 		}
 		for i = 0; i < num_nics; i++ {
-			nn = append(nn, NICInfo{
-				BytesTransmitted: dev_net_bytes(domain, f_NET_TX, i),
-				BytesReceived:    dev_net_bytes(domain, f_NET_RX, i),
+			id, err := dev_net_id(domain, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			bytesTransmitted, err := dev_net_stat(domain, f_NET_TX, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			bytesReceived, err := dev_net_stat(domain, f_NET_RX, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			packetsTransmitted, err := dev_net_stat(domain, f_NET_TXPKT, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			packetsReceived, err := dev_net_stat(domain, f_NET_RXPKT, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			errorsTransmitted, err := dev_net_stat(domain, f_NET_TXERR, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			errorsReceived, err := dev_net_stat(domain, f_NET_RXERR, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			dropsTransmitted, err := dev_net_stat(domain, f_NET_TXDROP, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			dropsReceived, err := dev_net_stat(domain, f_NET_RXDROP, i)
+			if err != nil {
+				log.Printf("%s: %s", name, err)
+				continue
+			}
+			nicinfo := NICInfo{
+				ID:                 id,
+				BytesTransmitted:   bytesTransmitted,
+				BytesReceived:      bytesReceived,
+				PacketsTransmitted: packetsTransmitted,
+				PacketsReceived:    packetsReceived,
+				ErrorsTransmitted:  errorsTransmitted,
+				ErrorsReceived:     errorsReceived,
+				DropsTransmitted:   dropsTransmitted,
+				DropsReceived:      dropsReceived,
+			}
+			nn = append(nn, nicinfo)
+		}
+		for i = 0; i < num_vcpus; i++ {
+			vcpu := C.xenstat_domain_vcpu(domain, C.uint(i))
+			if vcpu == nil {
+				log.Printf("%s: could not get VCPU %d", name, i)
+				cc = append(cc, VCPUInfo{})
+				continue
+			}
+			cc = append(cc, VCPUInfo{
+				Online:  C.xenstat_vcpu_online(vcpu) != 0,
+				Seconds: float64(uint64(C.xenstat_vcpu_ns(vcpu))) / 1000 / 1000 / 1000,
 			})
 		}
 
+		domid := uint32(C.xenstat_domain_id(domain))
+		var memory MemoryDetails
+		if x.opts.flags&CollectMemoryDetails != 0 && x.xs != nil {
+			memory = ReadMemoryDetails(domid, func(p string) (string, bool) {
+				return xs_read_string(x.xs, p)
+			})
+		}
+		var reason string
+		if state&(Shutdown|Crashed) != 0 {
+			reason = x.domainShutdownReason(domid)
+		}
+		if reason == "" && state.Has(Crashed) {
+			reason = "crash"
+		}
 		domaindata = append(domaindata, DomainInfo{
-			name,
-			state,
-			float64(uint64(C.xenstat_domain_cpu_ns(domain))) / 1000 / 1000 / 1000,
-			uint32(C.xenstat_domain_num_vcpus(domain)),
-			uint64(C.xenstat_domain_cur_mem(domain)),
-			uint64(C.xenstat_domain_max_mem(domain)),
-			uint32(C.xenstat_domain_num_vbds(domain)),
-			uint32(C.xenstat_domain_num_networks(domain)),
-			vv,
-			nn,
+			Name:           name,
+			DomainID:       domid,
+			UUID:           x.domainUUID(domid),
+			State:          state,
+			CPUSeconds:     float64(uint64(C.xenstat_domain_cpu_ns(domain))) / 1000 / 1000 / 1000,
+			NumVCPUs:       uint32(C.xenstat_domain_num_vcpus(domain)),
+			MemoryBytes:    uint64(C.xenstat_domain_cur_mem(domain)),
+			MaxmemBytes:    uint64(C.xenstat_domain_max_mem(domain)),
+			NumVBDs:        num_vbds,
+			NumNICs:        num_nics,
+			VBDs:           vv,
+			NICs:           nn,
+			VCPUs:          cc,
+			Memory:         memory,
+			ShutdownReason: reason,
 		},
 		)
 	}
 
-	return domaindata, nil
+	return Snapshot{Node: nodedata, PCPUs: pcpudata, Domains: domaindata}, nil
 }
+
+var _ busySource = (*XenStats)(nil)
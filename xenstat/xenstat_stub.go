@@ -0,0 +1,51 @@
+//go:build !cgo || !libxenstat
+// +build !cgo !libxenstat
+
+package xenstat
+
+import (
+	"context"
+)
+
+// HaveLibxenstat is true when the libxenstat backend is built in.
+const HaveLibxenstat = false
+
+// XenStats is only available when building with cgo and the libxenstat
+// build tag, since it needs libxenstat and the Xen development files.
+// Otherwise every attempt to create one fails with ErrNotSupported; use
+// XentopBatch instead.
+type XenStats struct{}
+
+// NewXenStats always fails with ErrNotSupported.
+func NewXenStats(opts ...Option) (*XenStats, error) {
+	return nil, ErrNotSupported
+}
+
+// OpenXenStats is an Opener that always fails with ErrNotSupported.
+func OpenXenStats() (Source, error) {
+	return nil, ErrNotSupported
+}
+
+// OpenXenStatsWith returns an Opener that always fails with
+// ErrNotSupported.
+func OpenXenStatsWith(opts ...Option) Opener {
+	return OpenXenStats
+}
+
+// Close does nothing.
+func (x *XenStats) Close() {}
+
+// Poll always fails with ErrNotSupported.
+func (x *XenStats) Poll() ([]DomainInfo, error) {
+	return nil, ErrNotSupported
+}
+
+// PollSnapshot always fails with ErrNotSupported.
+func (x *XenStats) PollSnapshot() (Snapshot, error) {
+	return Snapshot{}, ErrNotSupported
+}
+
+// PollContext always fails with ErrNotSupported.
+func (x *XenStats) PollContext(ctx context.Context) (Snapshot, error) {
+	return Snapshot{}, ErrNotSupported
+}
@@ -0,0 +1,335 @@
+package xenstat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultXentopPath is the default location of the xentop program.
+const DefaultXentopPath = "xentop"
+
+// XentopBatch is a Source that runs xentop in batch mode and parses its
+// output, for hosts where linking against libxenstat is not an option.
+//
+// xentop does not report domain IDs, UUIDs or host information, so those
+// are left empty in the returned snapshots; every domain has DomainID 0.
+// CPU time is only reported with a precision of one second, except per
+// VCPU.
+//
+// This code is thread-safe.
+type XentopBatch struct {
+	path       string
+	iterations int
+	delay      time.Duration
+	closed     bool
+	mu         sync.Mutex
+}
+
+// NewXentopBatch returns a XentopBatch that runs the xentop program at
+// path for the given number of iterations, waiting delay between them.
+// Only the last iteration is returned by polls.
+func NewXentopBatch(path string, iterations int, delay time.Duration) *XentopBatch {
+	if iterations < 1 {
+		iterations = 1
+	}
+	return &XentopBatch{path: path, iterations: iterations, delay: delay}
+}
+
+// OpenXentopBatch returns an Opener of XentopBatch.  The Opener fails with
+// ErrCannotConnect if the xentop program cannot be found.
+func OpenXentopBatch(path string, iterations int, delay time.Duration) Opener {
+	return func() (Source, error) {
+		if _, err := exec.LookPath(path); err != nil {
+			return nil, ErrCannotConnect
+		}
+		return NewXentopBatch(path, iterations, delay), nil
+	}
+}
+
+// PollSnapshot runs xentop and returns the last iteration of its output.
+func (x *XentopBatch) PollSnapshot() (Snapshot, error) {
+	return x.PollContext(context.Background())
+}
+
+// PollContext is like PollSnapshot, but kills xentop once ctx is done.
+func (x *XentopBatch) PollContext(ctx context.Context) (Snapshot, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.closed {
+		return Snapshot{}, ErrDisconnected
+	}
+
+	delay := int(x.delay / time.Second)
+	if delay < 1 {
+		delay = 1
+	}
+	cmd := exec.CommandContext(ctx, x.path,
+		"-b", "-f", "-x", "-v", "-n",
+		"-i", strconv.Itoa(x.iterations),
+		"-d", strconv.Itoa(delay),
+	)
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return Snapshot{}, ctx.Err()
+	}
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("%w: %s: %s", ErrDisconnected, x.path, err)
+	}
+	return ParseXentopBatch(strings.NewReader(string(out)))
+}
+
+// Close marks the XentopBatch as closed.
+func (x *XentopBatch) Close() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.closed = true
+}
+
+// xentopStates maps the letters of the xentop STATE column to the flags.
+var xentopStates = map[byte]DomainState{
+	'd': Dying,
+	's': Shutdown,
+	'b': Blocked,
+	'c': Crashed,
+	'p': Paused,
+	'r': Running,
+}
+
+// xentopColumn returns the field under the named column of the header.
+func xentopColumn(header map[string]int, fields []string, name string) (string, bool) {
+	i, ok := header[name]
+	if !ok || i >= len(fields) {
+		return "", false
+	}
+	return fields[i], true
+}
+
+func xentopUint(header map[string]int, fields []string, name string) (uint64, error) {
+	v, ok := xentopColumn(header, fields, name)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("column %s: %s", name, err)
+	}
+	return n, nil
+}
+
+// trimUnit parses a number followed by a unit, such as "1234bytes".
+func trimUnit(v, unit string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSuffix(v, unit), 10, 64)
+}
+
+// parseXentopNet parses a line such as
+// "Net0 RX: 1234bytes 5pkts 0err 0drop  TX: 4321bytes 6pkts 0err 0drop".
+func parseXentopNet(fields []string) (NICInfo, error) {
+	var n NICInfo
+	if len(fields) != 11 || fields[1] != "RX:" || fields[6] != "TX:" {
+		return n, fmt.Errorf("unexpected network line %q", strings.Join(fields, " "))
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "Net"), 10, 32)
+	if err != nil {
+		return n, fmt.Errorf("network id: %s", err)
+	}
+	n.ID = uint32(id)
+	units := []string{"bytes", "pkts", "err", "drop"}
+	counters := []*uint64{
+		&n.BytesReceived, &n.PacketsReceived, &n.ErrorsReceived, &n.DropsReceived,
+		&n.BytesTransmitted, &n.PacketsTransmitted, &n.ErrorsTransmitted, &n.DropsTransmitted,
+	}
+	for i, dst := range counters {
+		// Skip the "TX:" marker between the receive and transmit counters.
+		field := fields[2+i+i/4]
+		if *dst, err = trimUnit(field, units[i%4]); err != nil {
+			return n, fmt.Errorf("network %d: %s", n.ID, err)
+		}
+	}
+	return n, nil
+}
+
+// parseXentopVBD parses a line such as
+// "VBD BlkBack 51712 [ca: 0]  OO: 0   RD: 1   WR: 2  RSECT: 3  WSECT: 4".
+// The bracketed major and minor numbers are printed in hexadecimal and
+// padded with spaces, so they are taken from the device number instead.
+func parseXentopVBD(line string) (VBDInfo, error) {
+	var v VBDInfo
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return v, fmt.Errorf("unexpected VBD line %q", line)
+	}
+	dev, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return v, fmt.Errorf("VBD device: %s", err)
+	}
+	v.Dev = uint32(dev)
+	v.Device = vdevName(v.Dev)
+	v.Major = uint8(255 & (dev >> 8))
+	v.Minor = uint8(255 & dev)
+	var sectorsRead, sectorsWritten uint64
+	counters := map[string]*uint64{
+		"OO:":    &v.OutOfRequests,
+		"RD:":    &v.ReadRequests,
+		"WR:":    &v.WriteRequests,
+		"RSECT:": &sectorsRead,
+		"WSECT:": &sectorsWritten,
+	}
+	for i := 3; i < len(fields)-1; i++ {
+		dst, ok := counters[fields[i]]
+		if !ok {
+			continue
+		}
+		if *dst, err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+			return v, fmt.Errorf("VBD %d %s %s", dev, fields[i], err)
+		}
+		i++
+	}
+	v.BytesRead = sectorsRead * 512
+	v.BytesWritten = sectorsWritten * 512
+	return v, nil
+}
+
+// parseXentopVCPUs parses a line such as "VCPUs(sec):   0:   12s  1:   34s".
+func parseXentopVCPUs(fields []string) ([]VCPUInfo, error) {
+	var cc []VCPUInfo
+	for i := 1; i+1 < len(fields); i += 2 {
+		n, err := strconv.ParseUint(strings.TrimSuffix(fields[i], ":"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("VCPU number: %s", err)
+		}
+		secs, err := trimUnit(fields[i+1], "s")
+		if err != nil {
+			return nil, fmt.Errorf("VCPU %d: %s", n, err)
+		}
+		for uint64(len(cc)) < n {
+			cc = append(cc, VCPUInfo{})
+		}
+		cc = append(cc, VCPUInfo{Online: true, Seconds: float64(secs)})
+	}
+	return cc, nil
+}
+
+// parseXentopDomain parses a domain line according to the column header.
+func parseXentopDomain(header map[string]int, line string) (DomainInfo, error) {
+	var d DomainInfo
+	// MAXMEM(k) is "no limit" for domains without a memory limit.
+	fields := strings.Fields(strings.Replace(line, "no limit", "no_limit", 1))
+
+	name, _ := xentopColumn(header, fields, "NAME")
+	d.Name = name
+	if state, ok := xentopColumn(header, fields, "STATE"); ok {
+		for i := 0; i < len(state); i++ {
+			d.State |= xentopStates[state[i]]
+		}
+	}
+
+	cpu, err := xentopUint(header, fields, "CPU(sec)")
+	if err != nil {
+		return d, fmt.Errorf("%s: %s", name, err)
+	}
+	d.CPUSeconds = float64(cpu)
+	mem, err := xentopUint(header, fields, "MEM(k)")
+	if err != nil {
+		return d, fmt.Errorf("%s: %s", name, err)
+	}
+	d.MemoryBytes = mem * 1024
+	if maxmem, ok := xentopColumn(header, fields, "MAXMEM(k)"); ok && maxmem != "no_limit" {
+		m, err := strconv.ParseUint(maxmem, 10, 64)
+		if err != nil {
+			return d, fmt.Errorf("%s: column MAXMEM(k): %s", name, err)
+		}
+		d.MaxmemBytes = m * 1024
+	}
+	for col, dst := range map[string]*uint32{
+		"VCPUS": &d.NumVCPUs,
+		"NETS":  &d.NumNICs,
+		"VBDS":  &d.NumVBDs,
+	} {
+		n, err := xentopUint(header, fields, col)
+		if err != nil {
+			return d, fmt.Errorf("%s: %s", name, err)
+		}
+		*dst = uint32(n)
+	}
+	return d, nil
+}
+
+// ParseXentopBatch parses the output of xentop in batch mode, and returns
+// the last iteration.  The VBD, VCPU and network detail lines produced by
+// the -x, -v and -n options of xentop are parsed if present.
+func ParseXentopBatch(r io.Reader) (Snapshot, error) {
+	var header map[string]int
+	var current []DomainInfo
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "NAME":
+			header = make(map[string]int)
+			for i, f := range fields {
+				header[f] = i
+			}
+			current = []DomainInfo{}
+		case header == nil:
+			continue
+		case fields[0] == "VCPUs(sec):":
+			if len(current) == 0 {
+				continue
+			}
+			cc, err := parseXentopVCPUs(fields)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			current[len(current)-1].VCPUs = cc
+		case strings.HasPrefix(fields[0], "Net") && len(fields) > 1 && fields[1] == "RX:":
+			if len(current) == 0 {
+				continue
+			}
+			n, err := parseXentopNet(fields)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			d := &current[len(current)-1]
+			d.NICs = append(d.NICs, n)
+		case fields[0] == "VBD":
+			if len(current) == 0 {
+				continue
+			}
+			v, err := parseXentopVBD(line)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			d := &current[len(current)-1]
+			d.VBDs = append(d.VBDs, v)
+		default:
+			d, err := parseXentopDomain(header, line)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			current = append(current, d)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	if header == nil {
+		return Snapshot{}, fmt.Errorf("%w: no xentop header found", ErrDisconnected)
+	}
+	return Snapshot{Domains: current}, nil
+}
+
+var _ ContextSource = (*XentopBatch)(nil)
@@ -0,0 +1,82 @@
+package xenstat
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseXentopBatch(t *testing.T) {
+	f, err := os.Open("testdata/xentop-batch.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s, err := ParseXentopBatch(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the second iteration is returned.
+	want := []DomainInfo{
+		{
+			Name:        "Domain-0",
+			State:       Running,
+			CPUSeconds:  912,
+			NumVCPUs:    2,
+			MemoryBytes: 4194304 * 1024,
+			VCPUs: []VCPUInfo{
+				{Online: true, Seconds: 600},
+				{Online: true, Seconds: 300},
+			},
+		},
+		{
+			Name:        "web1",
+			State:       Blocked,
+			CPUSeconds:  56,
+			NumVCPUs:    2,
+			MemoryBytes: 1048576 * 1024,
+			MaxmemBytes: 1049600 * 1024,
+			NumVBDs:     1,
+			NumNICs:     1,
+			VBDs: []VBDInfo{
+				{
+					Dev:           51712,
+					Device:        "xvda",
+					Major:         202,
+					Minor:         0,
+					ReadRequests:  10,
+					WriteRequests: 20,
+					BytesRead:     80 * 512,
+					BytesWritten:  160 * 512,
+				},
+			},
+			NICs: []NICInfo{
+				{
+					ID:                 0,
+					BytesReceived:      466944,
+					PacketsReceived:    3000,
+					DropsReceived:      5,
+					BytesTransmitted:   125952,
+					PacketsTransmitted: 1500,
+					ErrorsTransmitted:  1,
+				},
+			},
+			VCPUs: []VCPUInfo{
+				{Online: true, Seconds: 30},
+				{Online: true, Seconds: 26},
+			},
+		},
+	}
+	if !reflect.DeepEqual(s.Domains, want) {
+		t.Errorf("got %+v\nwant %+v", s.Domains, want)
+	}
+}
+
+func TestParseXentopBatchWithoutHeader(t *testing.T) {
+	if _, err := ParseXentopBatch(strings.NewReader("xentop: not running on Xen\n")); err == nil {
+		t.Error("expected an error for output without header")
+	}
+}
@@ -0,0 +1,275 @@
+package xenstore
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeServer is an in-process xenstored, for testing code that uses
+// Client without a Xen host.  It implements reads, directory listings,
+// writes, removals, transactions and watches.  Permissions are not
+// enforced.
+//
+// This code is thread-safe.
+type FakeServer struct {
+	nodes  map[string]string
+	gen    uint64
+	nextTx uint32
+	conns  map[*fakeConn]bool
+	mu     sync.Mutex
+}
+
+type fakeWatch struct {
+	path  string
+	token string
+}
+
+type fakeTx struct {
+	nodes map[string]string
+	gen   uint64
+}
+
+type fakeConn struct {
+	conn    net.Conn
+	watches []fakeWatch
+	txs     map[uint32]*fakeTx
+	wmu     sync.Mutex
+}
+
+// NewFakeServer returns a FakeServer with an empty tree.
+func NewFakeServer() *FakeServer {
+	return &FakeServer{
+		nodes: map[string]string{"/": ""},
+		conns: make(map[*fakeConn]bool),
+	}
+}
+
+// Client returns a Client connected to the FakeServer.
+func (s *FakeServer) Client() *Client {
+	client, server := net.Pipe()
+	fc := &fakeConn{conn: server, txs: make(map[uint32]*fakeTx)}
+	s.mu.Lock()
+	s.conns[fc] = true
+	s.mu.Unlock()
+	go s.serve(fc)
+	return NewClient(client)
+}
+
+// Set writes value at path, creating any missing parents, and fires
+// the matching watches.
+func (s *FakeServer) Set(path, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fakeWrite(s.nodes, path, value)
+	s.gen++
+	s.fire(path)
+}
+
+// Delete removes path and everything below it, and fires the matching
+// watches.
+func (s *FakeServer) Delete(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fakeRemove(s.nodes, path)
+	s.gen++
+	s.fire(path)
+}
+
+func parent(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func fakeWrite(nodes map[string]string, path, value string) {
+	for p := parent(path); p != "/"; p = parent(p) {
+		if _, ok := nodes[p]; !ok {
+			nodes[p] = ""
+		}
+	}
+	nodes[path] = value
+}
+
+func fakeRemove(nodes map[string]string, path string) {
+	for p := range nodes {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(nodes, p)
+		}
+	}
+}
+
+func fakeDirectory(nodes map[string]string, path string) ([]string, error) {
+	if _, ok := nodes[path]; !ok {
+		return nil, ENOENT
+	}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	var children []string
+	for p := range nodes {
+		if p != path && strings.HasPrefix(p, prefix) && !strings.Contains(p[len(prefix):], "/") {
+			children = append(children, p[len(prefix):])
+		}
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+// fire sends watch events for a change of path.  The caller must hold
+// the lock.
+func (s *FakeServer) fire(path string) {
+	for fc := range s.conns {
+		for _, w := range fc.watches {
+			if path == w.path || strings.HasPrefix(path, strings.TrimSuffix(w.path, "/")+"/") {
+				go fc.send(message{xsWatchEvent, 0, 0, joinArgs(path, w.token)})
+			}
+		}
+	}
+}
+
+func (fc *fakeConn) send(m message) {
+	fc.wmu.Lock()
+	defer fc.wmu.Unlock()
+	writeMessage(fc.conn, m)
+}
+
+func (s *FakeServer) serve(fc *fakeConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, fc)
+		s.mu.Unlock()
+		fc.conn.Close()
+	}()
+	for {
+		m, err := readMessage(fc.conn)
+		if err != nil {
+			return
+		}
+		reply, err := s.handle(fc, m)
+		if err != nil {
+			fc.send(message{xsError, m.reqID, m.txID, joinArgs(string(err.(Error)))})
+			continue
+		}
+		fc.send(message{m.typ, m.reqID, m.txID, reply})
+	}
+}
+
+func (s *FakeServer) handle(fc *fakeConn, m message) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	args := splitArgs(m.payload)
+	nodes := s.nodes
+	if m.txID != 0 {
+		tx, ok := fc.txs[m.txID]
+		if !ok {
+			return nil, ENOENT
+		}
+		nodes = tx.nodes
+	}
+
+	switch m.typ {
+	case xsRead:
+		if len(args) < 1 {
+			return nil, EINVAL
+		}
+		v, ok := nodes[args[0]]
+		if !ok {
+			return nil, ENOENT
+		}
+		return []byte(v), nil
+	case xsDirectory:
+		if len(args) < 1 {
+			return nil, EINVAL
+		}
+		children, err := fakeDirectory(nodes, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return joinArgs(children...), nil
+	case xsWrite:
+		i := strings.IndexByte(string(m.payload), 0)
+		if i < 0 {
+			return nil, EINVAL
+		}
+		path, value := string(m.payload[:i]), string(m.payload[i+1:])
+		fakeWrite(nodes, path, value)
+		if m.txID == 0 {
+			s.gen++
+			s.fire(path)
+		}
+		return joinArgs("OK"), nil
+	case xsRm:
+		if len(args) < 1 {
+			return nil, EINVAL
+		}
+		if _, ok := nodes[args[0]]; !ok {
+			return nil, ENOENT
+		}
+		fakeRemove(nodes, args[0])
+		if m.txID == 0 {
+			s.gen++
+			s.fire(args[0])
+		}
+		return joinArgs("OK"), nil
+	case xsWatch:
+		if len(args) < 2 {
+			return nil, EINVAL
+		}
+		fc.watches = append(fc.watches, fakeWatch{args[0], args[1]})
+		go fc.send(message{xsWatchEvent, 0, 0, joinArgs(args[0], args[1])})
+		return joinArgs("OK"), nil
+	case xsUnwatch:
+		if len(args) < 2 {
+			return nil, EINVAL
+		}
+		for i, w := range fc.watches {
+			if w.path == args[0] && w.token == args[1] {
+				fc.watches = append(fc.watches[:i], fc.watches[i+1:]...)
+				return joinArgs("OK"), nil
+			}
+		}
+		return nil, ENOENT
+	case xsTransactionStart:
+		s.nextTx++
+		copied := make(map[string]string, len(s.nodes))
+		for k, v := range s.nodes {
+			copied[k] = v
+		}
+		fc.txs[s.nextTx] = &fakeTx{copied, s.gen}
+		return joinArgs(strconv.FormatUint(uint64(s.nextTx), 10)), nil
+	case xsTransactionEnd:
+		tx, ok := fc.txs[m.txID]
+		if !ok || len(args) < 1 {
+			return nil, EINVAL
+		}
+		delete(fc.txs, m.txID)
+		if args[0] != "T" {
+			return joinArgs("OK"), nil
+		}
+		if tx.gen != s.gen {
+			return nil, EAGAIN
+		}
+		var changed []string
+		for k, v := range tx.nodes {
+			if old, ok := s.nodes[k]; !ok || old != v {
+				changed = append(changed, k)
+			}
+		}
+		for k := range s.nodes {
+			if _, ok := tx.nodes[k]; !ok {
+				changed = append(changed, k)
+			}
+		}
+		s.nodes = tx.nodes
+		s.gen++
+		for _, k := range changed {
+			s.fire(k)
+		}
+		return joinArgs("OK"), nil
+	}
+	return nil, EINVAL
+}
@@ -0,0 +1,126 @@
+package xenstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// msgType is the type of a xenstore wire protocol message.
+type msgType uint32
+
+const (
+	xsControl          msgType = 0
+	xsDirectory        msgType = 1
+	xsRead             msgType = 2
+	xsGetPerms         msgType = 3
+	xsWatch            msgType = 4
+	xsUnwatch          msgType = 5
+	xsTransactionStart msgType = 6
+	xsTransactionEnd   msgType = 7
+	xsIntroduce        msgType = 8
+	xsRelease          msgType = 9
+	xsGetDomainPath    msgType = 10
+	xsWrite            msgType = 11
+	xsMkdir            msgType = 12
+	xsRm               msgType = 13
+	xsSetPerms         msgType = 14
+	xsWatchEvent       msgType = 15
+	xsError            msgType = 16
+)
+
+// maxPayload is the largest payload xenstored accepts.
+const maxPayload = 4096
+
+// headerSize is the size of the fixed message header.
+const headerSize = 16
+
+// message is a single xenstore wire protocol message.
+type message struct {
+	typ     msgType
+	reqID   uint32
+	txID    uint32
+	payload []byte
+}
+
+// readMessage reads one message from r.
+func readMessage(r io.Reader) (message, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return message{}, err
+	}
+	m := message{
+		typ:   msgType(binary.LittleEndian.Uint32(hdr[0:4])),
+		reqID: binary.LittleEndian.Uint32(hdr[4:8]),
+		txID:  binary.LittleEndian.Uint32(hdr[8:12]),
+	}
+	length := binary.LittleEndian.Uint32(hdr[12:16])
+	if length > maxPayload {
+		return message{}, fmt.Errorf("xenstore message payload too long (%d bytes)", length)
+	}
+	m.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, m.payload); err != nil {
+		return message{}, err
+	}
+	return m, nil
+}
+
+// writeMessage writes m to w in a single call.
+func writeMessage(w io.Writer, m message) error {
+	if len(m.payload) > maxPayload {
+		return fmt.Errorf("xenstore message payload too long (%d bytes)", len(m.payload))
+	}
+	buf := make([]byte, headerSize+len(m.payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(m.typ))
+	binary.LittleEndian.PutUint32(buf[4:8], m.reqID)
+	binary.LittleEndian.PutUint32(buf[8:12], m.txID)
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(len(m.payload)))
+	copy(buf[headerSize:], m.payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// joinArgs builds a payload out of NUL-terminated strings.
+func joinArgs(args ...string) []byte {
+	var b []byte
+	for _, a := range args {
+		b = append(b, a...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// splitArgs splits a payload made of NUL-terminated strings.
+func splitArgs(b []byte) []string {
+	var args []string
+	start := 0
+	for i, c := range b {
+		if c == 0 {
+			args = append(args, string(b[start:i]))
+			start = i + 1
+		}
+	}
+	if start < len(b) {
+		args = append(args, string(b[start:]))
+	}
+	return args
+}
+
+// Error is an error reported by xenstored, named after the errno value
+// it stands for.
+type Error string
+
+func (e Error) Error() string {
+	return "xenstore: " + string(e)
+}
+
+// Errors commonly reported by xenstored.
+const (
+	ENOENT Error = "ENOENT"
+	EACCES Error = "EACCES"
+	EEXIST Error = "EEXIST"
+	EINVAL Error = "EINVAL"
+	EAGAIN Error = "EAGAIN"
+	EBUSY  Error = "EBUSY"
+	E2BIG  Error = "E2BIG"
+)
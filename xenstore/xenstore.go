@@ -0,0 +1,345 @@
+// Package xenstore implements a client of the xenstored wire protocol,
+// so that xenstore can be read without libxenstore or cgo.
+package xenstore
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultSocketPath is where xenstored listens for local connections.
+const DefaultSocketPath = "/var/run/xenstored/socket"
+
+// DefaultDevicePath is the xenbus device, usable when xenstored runs in
+// another domain or its socket is not available.
+const DefaultDevicePath = "/dev/xen/xenbus"
+
+// ErrClosed is returned by operations on a closed Client, or after the
+// connection to xenstored has been lost.
+var ErrClosed = errors.New("xenstore: connection closed")
+
+// WatchEvent is delivered when a watched path, or any path below it,
+// changes.
+type WatchEvent struct {
+	// Path is the path that changed.
+	Path string
+	// Token is the token the watch was registered with.
+	Token string
+}
+
+// Client is a connection to xenstored.
+//
+// This code is thread-safe.
+type Client struct {
+	conn    io.ReadWriteCloser
+	nextID  uint32
+	pending map[uint32]chan message
+	watches map[string]chan WatchEvent
+	err     error
+	wmu     sync.Mutex
+	mu      sync.Mutex
+}
+
+// NewClient speaks the xenstore protocol over conn, which the Client
+// takes ownership of.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{
+		conn:    conn,
+		pending: make(map[uint32]chan message),
+		watches: make(map[string]chan WatchEvent),
+	}
+	go c.reader()
+	return c
+}
+
+// Dial connects to the xenstored socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// OpenDevice connects to xenstore through the xenbus device at path.
+func OpenDevice(path string) (*Client, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(f), nil
+}
+
+// Open connects to xenstore through path, which is either a xenbus device
+// or a xenstored socket.
+func Open(path string) (*Client, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeCharDevice != 0 {
+		return OpenDevice(path)
+	}
+	return Dial(path)
+}
+
+// Connect connects to xenstored through DefaultSocketPath, falling back
+// to DefaultDevicePath.
+func Connect() (*Client, error) {
+	c, err := Dial(DefaultSocketPath)
+	if err == nil {
+		return c, nil
+	}
+	c, derr := OpenDevice(DefaultDevicePath)
+	if derr == nil {
+		return c, nil
+	}
+	return nil, err
+}
+
+// reader dispatches incoming messages until the connection fails.
+func (c *Client) reader() {
+	for {
+		m, err := readMessage(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if m.typ == xsWatchEvent {
+			args := splitArgs(m.payload)
+			if len(args) < 2 {
+				continue
+			}
+			c.mu.Lock()
+			if ch, ok := c.watches[args[1]]; ok {
+				// Events are dropped rather than stalling every other
+				// request if the watcher is not keeping up.
+				select {
+				case ch <- WatchEvent{Path: args[0], Token: args[1]}:
+				default:
+				}
+			}
+			c.mu.Unlock()
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[m.reqID]
+		delete(c.pending, m.reqID)
+		c.mu.Unlock()
+		if ok {
+			ch <- m
+		}
+	}
+}
+
+// fail records err as the reason the connection is unusable, and wakes
+// everybody waiting on it.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	for token, ch := range c.watches {
+		close(ch)
+		delete(c.watches, token)
+	}
+}
+
+// request sends a message and waits for its reply.
+func (c *Client) request(typ msgType, txID uint32, payload []byte) ([]byte, error) {
+	ch := make(chan message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := writeMessage(c.conn, message{typ, id, txID, payload})
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	reply, ok := <-ch
+	if !ok {
+		return nil, ErrClosed
+	}
+	if reply.typ == xsError {
+		return nil, Error(strings.TrimRight(string(reply.payload), "\x00"))
+	}
+	if reply.typ != typ {
+		return nil, EINVAL
+	}
+	return reply.payload, nil
+}
+
+func (c *Client) read(txID uint32, path string) (string, error) {
+	b, err := c.request(xsRead, txID, joinArgs(path))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (c *Client) directory(txID uint32, path string) ([]string, error) {
+	b, err := c.request(xsDirectory, txID, joinArgs(path))
+	if err != nil {
+		return nil, err
+	}
+	return splitArgs(b), nil
+}
+
+func (c *Client) write(txID uint32, path, value string) error {
+	_, err := c.request(xsWrite, txID, append(joinArgs(path), value...))
+	return err
+}
+
+func (c *Client) remove(txID uint32, path string) error {
+	_, err := c.request(xsRm, txID, joinArgs(path))
+	return err
+}
+
+// Read returns the value at path.  If the path does not exist, the error
+// is ENOENT.
+func (c *Client) Read(path string) (string, error) {
+	return c.read(0, path)
+}
+
+// Directory returns the names of the children of path.
+func (c *Client) Directory(path string) ([]string, error) {
+	return c.directory(0, path)
+}
+
+// Write sets the value at path, creating it if needed.
+func (c *Client) Write(path, value string) error {
+	return c.write(0, path, value)
+}
+
+// Remove deletes path and everything below it.
+func (c *Client) Remove(path string) error {
+	return c.remove(0, path)
+}
+
+// Watch registers a watch on path and everything below it.  Events are
+// sent on the returned channel, starting with one for path itself, as
+// xenstored always does.  The channel is closed when the watch is removed
+// with Unwatch or the connection is lost.  Events are dropped if the
+// channel is not drained quickly enough.
+func (c *Client) Watch(path, token string) (<-chan WatchEvent, error) {
+	ch := make(chan WatchEvent, 16)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if _, ok := c.watches[token]; ok {
+		c.mu.Unlock()
+		return nil, EEXIST
+	}
+	c.watches[token] = ch
+	c.mu.Unlock()
+
+	if _, err := c.request(xsWatch, 0, joinArgs(path, token)); err != nil {
+		c.mu.Lock()
+		if c.watches[token] == ch {
+			delete(c.watches, token)
+			close(ch)
+		}
+		c.mu.Unlock()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Unwatch removes a watch registered with Watch, and closes its channel.
+func (c *Client) Unwatch(path, token string) error {
+	_, err := c.request(xsUnwatch, 0, joinArgs(path, token))
+	c.mu.Lock()
+	if ch, ok := c.watches[token]; ok {
+		delete(c.watches, token)
+		close(ch)
+	}
+	c.mu.Unlock()
+	return err
+}
+
+// Transaction starts a transaction.  Operations done through the returned
+// Transaction are applied atomically once it is committed.
+func (c *Client) Transaction() (*Transaction, error) {
+	b, err := c.request(xsTransactionStart, 0, joinArgs(""))
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseUint(strings.TrimRight(string(b), "\x00"), 10, 32)
+	if err != nil {
+		return nil, EINVAL
+	}
+	return &Transaction{c, uint32(id)}, nil
+}
+
+// Close closes the connection to xenstored.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	c.fail(ErrClosed)
+	return err
+}
+
+// Transaction is a xenstore transaction.  Either Commit or Abort must be
+// called once done with it.
+type Transaction struct {
+	c  *Client
+	id uint32
+}
+
+// Read returns the value at path as seen by the transaction.
+func (t *Transaction) Read(path string) (string, error) {
+	return t.c.read(t.id, path)
+}
+
+// Directory returns the names of the children of path as seen by the
+// transaction.
+func (t *Transaction) Directory(path string) ([]string, error) {
+	return t.c.directory(t.id, path)
+}
+
+// Write sets the value at path within the transaction.
+func (t *Transaction) Write(path, value string) error {
+	return t.c.write(t.id, path, value)
+}
+
+// Remove deletes path and everything below it within the transaction.
+func (t *Transaction) Remove(path string) error {
+	return t.c.remove(t.id, path)
+}
+
+// Commit applies the transaction.  If another transaction modified the
+// same data in the meantime, the error is EAGAIN and the whole transaction
+// should be retried.
+func (t *Transaction) Commit() error {
+	_, err := t.c.request(xsTransactionEnd, t.id, joinArgs("T"))
+	return err
+}
+
+// Abort discards the transaction.
+func (t *Transaction) Abort() error {
+	_, err := t.c.request(xsTransactionEnd, t.id, joinArgs("F"))
+	return err
+}
@@ -0,0 +1,114 @@
+package xenstore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadDirectory(t *testing.T) {
+	srv := NewFakeServer()
+	srv.Set("/local/domain/1/name", "web1")
+	srv.Set("/local/domain/2/name", "db1")
+	c := srv.Client()
+	defer c.Close()
+
+	v, err := c.Read("/local/domain/1/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "web1" {
+		t.Errorf("got %q, want web1", v)
+	}
+
+	children, err := c.Directory("/local/domain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(children, want) {
+		t.Errorf("got %q, want %q", children, want)
+	}
+
+	if _, err := c.Read("/local/domain/3/name"); err != ENOENT {
+		t.Errorf("reading a missing path: got %v, want ENOENT", err)
+	}
+	if _, err := c.Directory("/local/domain/3"); err != ENOENT {
+		t.Errorf("listing a missing path: got %v, want ENOENT", err)
+	}
+}
+
+func TestTransactionConflict(t *testing.T) {
+	srv := NewFakeServer()
+	srv.Set("/vm/counter", "1")
+	c := srv.Client()
+	defer c.Close()
+
+	tx, err := c.Transaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Write("/vm/counter", "2"); err != nil {
+		t.Fatal(err)
+	}
+	srv.Set("/vm/counter", "3")
+	if err := tx.Commit(); err != EAGAIN {
+		t.Fatalf("committing a conflicting transaction: got %v, want EAGAIN", err)
+	}
+	if v, err := c.Read("/vm/counter"); err != nil || v != "3" {
+		t.Errorf("got %q, %v after failed commit, want 3", v, err)
+	}
+
+	tx, err = c.Transaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Write("/vm/counter", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("retried commit: %v", err)
+	}
+	if v, err := c.Read("/vm/counter"); err != nil || v != "4" {
+		t.Errorf("got %q, %v after commit, want 4", v, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv := NewFakeServer()
+	c := srv.Client()
+	defer c.Close()
+
+	ch, err := c.Watch("/local/domain", "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() WatchEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Fatal("watch channel closed")
+			}
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a watch event")
+		}
+		return WatchEvent{}
+	}
+
+	if ev := next(); ev != (WatchEvent{"/local/domain", "tok"}) {
+		t.Errorf("got initial event %+v", ev)
+	}
+	srv.Set("/local/domain/5/name", "web1")
+	if ev := next(); ev != (WatchEvent{"/local/domain/5/name", "tok"}) {
+		t.Errorf("got event %+v", ev)
+	}
+	srv.Set("/vm/other", "x")
+
+	if err := c.Unwatch("/local/domain", "tok"); err != nil {
+		t.Fatal(err)
+	}
+	for ev := range ch {
+		t.Errorf("got event %+v for an unrelated path", ev)
+	}
+}
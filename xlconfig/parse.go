@@ -0,0 +1,189 @@
+package xlconfig
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token is a lexical element of an xl configuration file.
+type token struct {
+	kind byte // 'i'dentifier, 'n'umber, 's'tring, or the punctuation itself
+	text string
+	line int
+}
+
+// lex splits an xl configuration file into tokens.  Comments and line
+// breaks are dropped, since a statement ends with the value it assigns.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '=' || c == '[' || c == ']' || c == ',' || c == ';':
+			tokens = append(tokens, token{c, string(c), line})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			s := src[i+1 : i+1+end]
+			tokens = append(tokens, token{'s', s, line})
+			line += strings.Count(s, "\n")
+			i += end + 2
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{'i', src[i:j], line})
+			i = j
+		case c == '-' || c == '.' || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '.' || src[j] == 'x' || src[j] == 'X' || unicode.IsDigit(rune(src[j])) ||
+				(src[j] >= 'a' && src[j] <= 'f') || (src[j] >= 'A' && src[j] <= 'F')) {
+				j++
+			}
+			tokens = append(tokens, token{'n', src[i:j], line})
+			i = j
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	return tokens, nil
+}
+
+// parseSettings parses an xl configuration file into its settings.
+// Values are strings, numbers (float64), or lists of those.
+func parseSettings(src string) (map[string]interface{}, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]interface{})
+	i := 0
+	next := func() (token, bool) {
+		if i >= len(tokens) {
+			return token{}, false
+		}
+		i++
+		return tokens[i-1], true
+	}
+	scalar := func(t token) (interface{}, error) {
+		switch t.kind {
+		case 's':
+			return t.text, nil
+		case 'n':
+			if n, err := strconv.ParseInt(t.text, 0, 64); err == nil {
+				return float64(n), nil
+			}
+			n, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad number %q", t.line, t.text)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+	}
+
+	for {
+		t, ok := next()
+		if !ok {
+			return settings, nil
+		}
+		if t.kind == ';' {
+			continue
+		}
+		if t.kind != 'i' {
+			return nil, fmt.Errorf("line %d: expected a setting name, got %q", t.line, t.text)
+		}
+		name := t.text
+		if eq, ok := next(); !ok || eq.kind != '=' {
+			return nil, fmt.Errorf("line %d: expected = after %s", t.line, name)
+		}
+		v, ok := next()
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing value of %s", t.line, name)
+		}
+		if v.kind != '[' {
+			value, err := scalar(v)
+			if err != nil {
+				return nil, err
+			}
+			settings[name] = value
+			continue
+		}
+		list := []interface{}{}
+		for {
+			e, ok := next()
+			if !ok {
+				return nil, fmt.Errorf("line %d: unterminated list %s", v.line, name)
+			}
+			if e.kind == ']' {
+				break
+			}
+			if e.kind == ',' {
+				continue
+			}
+			value, err := scalar(e)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		settings[name] = list
+	}
+}
+
+// ParseConfig parses an xl domain configuration file, as found in
+// /etc/xen.  Settings that are left out take the defaults of xl.
+func ParseConfig(r io.Reader) (Config, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+	settings, err := parseSettings(string(b))
+	if err != nil {
+		return Config{}, err
+	}
+
+	c := Config{VCPUs: 1, MemoryBytes: defaultMemoryMiB << 20}
+	if v, ok := settings["name"].(string); ok {
+		c.Name = v
+	}
+	if v, ok := settings["vcpus"].(float64); ok {
+		c.VCPUs = uint32(v)
+	}
+	// Domains get maxvcpus VCPUs, of which only vcpus are online at boot.
+	if v, ok := settings["maxvcpus"].(float64); ok {
+		c.VCPUs = uint32(v)
+	}
+	if v, ok := settings["memory"].(float64); ok {
+		c.MemoryBytes = uint64(v) << 20
+	}
+	c.MaxmemBytes = c.MemoryBytes
+	if v, ok := settings["maxmem"].(float64); ok {
+		c.MaxmemBytes = uint64(v) << 20
+	}
+	if v, ok := settings["disk"].([]interface{}); ok {
+		c.Disks = uint32(len(v))
+	}
+	if v, ok := settings["vif"].([]interface{}); ok {
+		c.NICs = uint32(len(v))
+	}
+	return c, nil
+}
@@ -0,0 +1,159 @@
+// Package xlconfig reads what domains are configured with, from xl
+// configuration files or the output of "xl list -l", and compares it with
+// what the domains actually have, to detect drift caused by ballooning,
+// hotplug or manual xl commands.
+//
+// Nothing in this package needs a Xen host.
+package xlconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat/xeninfo"
+)
+
+// DefaultConfigDir is where xl configuration files are usually kept.
+const DefaultConfigDir = "/etc/xen"
+
+// defaultMemoryMiB is the memory xl gives domains that do not set it.
+const defaultMemoryMiB = 128
+
+// Config is what a domain is configured with.
+type Config struct {
+	// Name is the name of the domain.
+	Name string
+	// VCPUs is the number of virtual CPUs, online or not.
+	VCPUs uint32
+	// MemoryBytes is the memory the domain starts with.
+	MemoryBytes uint64
+	// MaxmemBytes is the most memory the domain can be ballooned up to.
+	MaxmemBytes uint64
+	// Disks is the number of virtual block devices.
+	Disks uint32
+	// NICs is the number of virtual network devices.
+	NICs uint32
+}
+
+// ReadConfigFile reads an xl configuration file.  Domains that do not set
+// their name are named after the file, without its .cfg extension.
+func ReadConfigFile(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	c, err := ParseConfig(f)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %s", path, err)
+	}
+	if c.Name == "" {
+		c.Name = strings.TrimSuffix(filepath.Base(path), ".cfg")
+	}
+	return c, nil
+}
+
+// ReadConfigDir reads every .cfg file in dir, and returns the
+// configurations keyed by domain name.  Files that cannot be read are
+// logged and skipped.
+func ReadConfigDir(dir string) (map[string]Config, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.cfg"))
+	if err != nil {
+		return nil, err
+	}
+	configs := make(map[string]Config)
+	for _, p := range paths {
+		c, err := ReadConfigFile(p)
+		if err != nil {
+			log.Printf("Skipping xl configuration file: %s", err)
+			continue
+		}
+		configs[c.Name] = c
+	}
+	return configs, nil
+}
+
+// xlListDomain is the part of the output of "xl list -l" that is used.
+type xlListDomain struct {
+	Config struct {
+		CInfo struct {
+			Name string `json:"name"`
+		} `json:"c_info"`
+		BInfo struct {
+			MaxVCPUs    uint32 `json:"max_vcpus"`
+			TargetMemKB uint64 `json:"target_memkb"`
+			MaxMemKB    uint64 `json:"max_memkb"`
+		} `json:"b_info"`
+		Disks []json.RawMessage `json:"disks"`
+		NICs  []json.RawMessage `json:"nics"`
+	} `json:"config"`
+}
+
+// ParseXLList parses the JSON output of "xl list -l", and returns the
+// configurations keyed by domain name.
+func ParseXLList(r io.Reader) (map[string]Config, error) {
+	var domains []xlListDomain
+	if err := json.NewDecoder(r).Decode(&domains); err != nil {
+		return nil, fmt.Errorf("xl list output: %s", err)
+	}
+	configs := make(map[string]Config)
+	for _, d := range domains {
+		c := d.Config
+		configs[c.CInfo.Name] = Config{
+			Name:        c.CInfo.Name,
+			VCPUs:       c.BInfo.MaxVCPUs,
+			MemoryBytes: c.BInfo.TargetMemKB * 1024,
+			MaxmemBytes: c.BInfo.MaxMemKB * 1024,
+			Disks:       uint32(len(c.Disks)),
+			NICs:        uint32(len(c.NICs)),
+		}
+	}
+	return configs, nil
+}
+
+// Drift compares a configured value of a domain with its actual value.
+type Drift struct {
+	// Field is the name of the compared value: vcpus, memory_target_bytes,
+	// maxmem_bytes, vbds or nics.
+	Field string
+	// Configured is the value in the configuration.
+	Configured float64
+	// Actual is the value the domain has.
+	Actual float64
+}
+
+// Delta returns how much the actual value exceeds the configured one.
+// It is zero when they agree.
+func (d Drift) Delta() float64 {
+	return d.Actual - d.Configured
+}
+
+// Compare compares the configuration of a domain with the domain.  Every
+// value that the domain information has is compared, even if it agrees
+// with the configuration; the balloon target and maximum memory are only
+// compared if known from xenstore.  The result is sorted by field.
+//
+// The maximum memory is compared with the static-max of xenstore, which
+// xl sets from maxmem, rather than with the maximum memory Xen reports,
+// which includes overhead such as the video RAM of HVM domains.
+func Compare(c Config, d xeninfo.DomainInfo) []Drift {
+	drifts := []Drift{
+		{"vcpus", float64(c.VCPUs), float64(d.NumVCPUs)},
+		{"vbds", float64(c.Disks), float64(d.NumVBDs)},
+		{"nics", float64(c.NICs), float64(d.NumNICs)},
+	}
+	if d.Memory.TargetBytes != 0 {
+		drifts = append(drifts, Drift{"memory_target_bytes", float64(c.MemoryBytes), float64(d.Memory.TargetBytes)})
+	}
+	if d.Memory.StaticMaxBytes != 0 {
+		drifts = append(drifts, Drift{"maxmem_bytes", float64(c.MaxmemBytes), float64(d.Memory.StaticMaxBytes)})
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Field < drifts[j].Field })
+	return drifts
+}
@@ -0,0 +1,92 @@
+package xlconfig
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Rudd-O/prometheus-xentop/xenstat/xeninfo"
+)
+
+func TestReadConfigDir(t *testing.T) {
+	configs, err := ReadConfigDir("testdata/etc-xen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// broken.cfg has an unterminated list and is skipped.
+	want := map[string]Config{
+		"web1": {
+			Name:        "web1",
+			VCPUs:       4,
+			MemoryBytes: 1024 << 20,
+			MaxmemBytes: 2048 << 20,
+			Disks:       2,
+			NICs:        1,
+		},
+		"db1": {
+			Name:        "db1",
+			VCPUs:       1,
+			MemoryBytes: defaultMemoryMiB << 20,
+			MaxmemBytes: defaultMemoryMiB << 20,
+			NICs:        2,
+		},
+	}
+	if !reflect.DeepEqual(configs, want) {
+		t.Errorf("got %+v\nwant %+v", configs, want)
+	}
+}
+
+func TestReadConfigFileError(t *testing.T) {
+	if _, err := ReadConfigFile("testdata/etc-xen/broken.cfg"); err == nil {
+		t.Error("expected an error for a malformed file")
+	}
+}
+
+func TestParseXLList(t *testing.T) {
+	f, err := os.Open("testdata/xl-list.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	configs, err := ParseXLList(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Config{
+		"web1": {
+			Name:        "web1",
+			VCPUs:       4,
+			MemoryBytes: 1024 << 20,
+			MaxmemBytes: 2048 << 20,
+			Disks:       2,
+			NICs:        1,
+		},
+	}
+	if !reflect.DeepEqual(configs, want) {
+		t.Errorf("got %+v\nwant %+v", configs, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	c := Config{Name: "web1", VCPUs: 4, MemoryBytes: 1024 << 20, MaxmemBytes: 2048 << 20, Disks: 2, NICs: 1}
+	d := xeninfo.DomainInfo{Name: "web1", NumVCPUs: 4, NumVBDs: 3, NumNICs: 1, MaxmemBytes: 2049 << 20}
+	d.Memory.TargetBytes = 768 << 20
+	d.Memory.StaticMaxBytes = 2048 << 20
+	want := []Drift{
+		{"maxmem_bytes", 2048 << 20, 2048 << 20},
+		{"memory_target_bytes", 1024 << 20, 768 << 20},
+		{"nics", 1, 1},
+		{"vbds", 2, 3},
+		{"vcpus", 4, 4},
+	}
+	got := Compare(c, d)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if delta := got[0].Delta(); delta != 0 {
+		t.Errorf("maxmem delta is %v, want 0", delta)
+	}
+	if delta := got[1].Delta(); delta != -256<<20 {
+		t.Errorf("memory delta is %v, want %v", delta, -256<<20)
+	}
+}